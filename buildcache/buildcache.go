@@ -0,0 +1,159 @@
+// Package buildcache records the content hashes of the inputs and
+// outputs of preamble commands so commands whose inputs and outputs are
+// unchanged since their last successful run can be skipped.
+package buildcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+// FileName is the name of the cache file, relative to the working
+// directory.
+const FileName = ".procrotator-cache.json"
+
+type entry struct {
+	Command     string `json:"command"`
+	InputsHash  string `json:"inputs_hash"`
+	OutputsHash string `json:"outputs_hash"`
+}
+
+// Cache is safe for concurrent use by the preambles of a cycle.
+type Cache struct {
+	path    string
+	locker  sync.Locker
+	entries map[string]entry
+}
+
+// Open loads the cache stored at path. A missing cache file, or one
+// that does not hold valid JSON, results in an empty cache. Other errors
+// reading the cache file are returned.
+func Open(path string) (*Cache, error) {
+	c := &Cache{
+		path:    path,
+		locker:  &sync.Mutex{},
+		entries: map[string]entry{},
+	}
+	if b, err := os.ReadFile(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("reading build cache: %w", err)
+	} else if err := json.Unmarshal(b, &c.entries); err != nil {
+		c.entries = map[string]entry{}
+	}
+	return c, nil
+}
+
+// Check reports whether the inputs and outputs of p are unchanged since
+// its last successful run. It also returns the hash of the current
+// inputs, to be passed to Record once p runs successfully.
+func (c *Cache) Check(p runtimeconfig.Preamble) (bool, string, error) {
+	inputsHash, err := hashGlobs(p.Inputs)
+	if err != nil {
+		return false, "", fmt.Errorf("hashing inputs: %w", err)
+	}
+	c.locker.Lock()
+	e, found := c.entries[p.Name]
+	c.locker.Unlock()
+	if !found || e.Command != p.Command || e.InputsHash != inputsHash {
+		return false, inputsHash, nil
+	}
+	if outputsHash, err := hashPaths(p.Outputs); err != nil {
+		// Missing outputs need to be rebuilt.
+		return false, inputsHash, nil
+	} else {
+		return outputsHash == e.OutputsHash, inputsHash, nil
+	}
+}
+
+// Record stores the result of a successful run of p and saves the cache.
+func (c *Cache) Record(p runtimeconfig.Preamble, inputsHash string) error {
+	outputsHash, err := hashPaths(p.Outputs)
+	if err != nil {
+		return fmt.Errorf("hashing outputs: %w", err)
+	}
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.entries[p.Name] = entry{
+		Command:     p.Command,
+		InputsHash:  inputsHash,
+		OutputsHash: outputsHash,
+	}
+	if b, err := json.MarshalIndent(c.entries, "", "  "); err != nil {
+		return err
+	} else if err := os.WriteFile(c.path, b, 0666); err != nil {
+		return fmt.Errorf("writing build cache: %w", err)
+	}
+	return nil
+}
+
+func hashGlobs(patterns []string) (string, error) {
+	if files, err := expandGlobs(patterns); err != nil {
+		return "", err
+	} else {
+		return hashFiles(files)
+	}
+}
+
+// hashPaths hashes the given files and the files within the given
+// directories. Every path must exist.
+func hashPaths(paths []string) (string, error) {
+	var files []string
+	for _, p := range paths {
+		if err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		}); err != nil {
+			return "", err
+		}
+	}
+	return hashFiles(files)
+}
+
+func hashFiles(files []string) (string, error) {
+	h := sha256.New()
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func filesOnly(paths []string) []string {
+	result := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func errorIsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
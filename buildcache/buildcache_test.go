@@ -0,0 +1,89 @@
+package buildcache_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/buildcache"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0666); err != nil {
+			panic(err)
+		}
+	}
+	writeFile("api/v1/service.proto", "service v1")
+	writeFile("api/README.md", "docs")
+	p := runtimeconfig.Preamble{
+		Name:    "protoc",
+		Command: "protoc",
+		Inputs:  []string{"api/**/*.proto"},
+		Outputs: []string{"gen"},
+	}
+
+	c, err := buildcache.Open(buildcache.FileName)
+	assert.NoError(t, err)
+	fresh, inputsHash, err := c.Check(p)
+	assert.NoError(t, err)
+	assert.False(t, fresh, "never run")
+
+	writeFile("gen/service.pb.go", "generated")
+	assert.NoError(t, c.Record(p, inputsHash))
+
+	// The cache survives reopening.
+	c, err = buildcache.Open(buildcache.FileName)
+	assert.NoError(t, err)
+	fresh, _, err = c.Check(p)
+	assert.NoError(t, err)
+	assert.True(t, fresh, "unchanged")
+
+	writeFile("api/README.md", "more docs")
+	fresh, _, err = c.Check(p)
+	assert.NoError(t, err)
+	assert.True(t, fresh, "non-input changed")
+
+	writeFile("gen/service.pb.go", "edited by hand")
+	fresh, _, err = c.Check(p)
+	assert.NoError(t, err)
+	assert.False(t, fresh, "output changed")
+	writeFile("gen/service.pb.go", "generated")
+
+	writeFile("api/v1/service.proto", "service v2")
+	fresh, _, err = c.Check(p)
+	assert.NoError(t, err)
+	assert.False(t, fresh, "input changed")
+	writeFile("api/v1/service.proto", "service v1")
+
+	p.Command = "protoc --experimental"
+	fresh, _, err = c.Check(p)
+	assert.NoError(t, err)
+	assert.False(t, fresh, "command changed")
+}
+
+func TestOpen(t *testing.T) {
+	d := t.TempDir()
+
+	// A missing cache file and one that is not valid JSON result in an
+	// empty cache.
+	assert.NoError(t, os.WriteFile(filepath.Join(d, "invalid.json"), []byte("{"), 0666))
+	for _, path := range []string{filepath.Join(d, "missing.json"), filepath.Join(d, "invalid.json")} {
+		c, err := buildcache.Open(path)
+		if assert.NoError(t, err, path) {
+			fresh, _, err := c.Check(runtimeconfig.Preamble{Name: "protoc", Command: "protoc"})
+			assert.NoError(t, err)
+			assert.False(t, fresh, path)
+		}
+	}
+
+	// Other errors reading the cache file are returned.
+	_, err := buildcache.Open(d)
+	assert.Error(t, err)
+}
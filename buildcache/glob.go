@@ -0,0 +1,93 @@
+package buildcache
+
+import (
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// expandGlobs returns the sorted, de-duplicated list of files matching
+// any of patterns. In addition to the syntax of filepath.Match, a path
+// segment of ** matches zero or more directories.
+func expandGlobs(patterns []string) ([]string, error) {
+	var result []string
+	for _, pattern := range patterns {
+		if matches, err := expandGlob(pattern); err != nil {
+			return nil, err
+		} else {
+			result = append(result, matches...)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+func expandGlob(pattern string) ([]string, error) {
+	pattern = filepath.Clean(pattern)
+	if !strings.Contains(pattern, "**") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return filesOnly(matches), nil
+	}
+
+	// Walk from the longest leading part of the pattern without any
+	// pattern syntax.
+	patternSegments := strings.Split(filepath.ToSlash(pattern), "/")
+	rootSegments := []string{}
+	for _, s := range patternSegments {
+		if strings.ContainsAny(s, `*?[\`) {
+			break
+		}
+		rootSegments = append(rootSegments, s)
+	}
+	root := filepath.FromSlash(strings.Join(rootSegments, "/"))
+	if root == "" && filepath.IsAbs(pattern) {
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	var result []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errorIsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if matchSegments(patternSegments, strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")) {
+			result = append(result, path)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// matchSegments reports whether the path segments match the pattern
+// segments, where a ** segment matches any number of path segments.
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pattern[0], path[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		path = path[1:]
+	}
+	return len(path) == 0
+}
@@ -6,18 +6,24 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/jakewan/go-procrotator/buildcache"
+	"github.com/jakewan/go-procrotator/command"
 	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/preamble"
 	"github.com/jakewan/go-procrotator/runtimeconfig"
 	"github.com/jakewan/go-procrotator/watchdirs"
 )
 
 type Dependencies interface {
 	Logger() logger.Logger
+	// Now returns the current time, against which the minimum restart
+	// interval is measured.
+	Now() time.Time
 }
 
 type procState int
@@ -27,10 +33,11 @@ const (
 	procStateStarting
 	procStateStarted
 	procStateStopping
+	procStateRotating
 )
 
 func (r procState) String() string {
-	return [...]string{"NotStarted", "Starting", "Started", "Stopping"}[r]
+	return [...]string{"NotStarted", "Starting", "Started", "Stopping", "Rotating"}[r]
 }
 
 func (r procState) EnumIndex() int {
@@ -40,14 +47,39 @@ func (r procState) EnumIndex() int {
 type state struct {
 	locker             sync.Locker
 	currentProcState   procState
-	cmd                *exec.Cmd
+	proc               *serverProcess
+	nextProc           *serverProcess
+	now                func() time.Time
 	lastRestartAt      time.Time
 	minRestartInterval time.Duration
+	restartCount       int
+	changedFiles       []string
+	cache              *buildcache.Cache
 }
 
+// serverProcess is a running server command along with the outcome of
+// waiting for it to exit.
+type serverProcess struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	err    error
+}
+
+// readinessGracePeriod is how long a new server process must stay up
+// before it is considered ready when no readiness command is configured.
+const readinessGracePeriod = time.Second
+
+// StartChildProcess starts the server process and restarts it after
+// changes received from fileChangedChan. Configurations received from
+// configUpdates apply to subsequent restarts, and restart the server
+// process right away when its command, environment or quit signal
+// changed.
+//
+// The server process is stopped when fileChangedChan is closed.
 func StartChildProcess(
 	deps Dependencies,
 	cfg runtimeconfig.Config,
+	configUpdates <-chan runtimeconfig.Config,
 	fileChangedChan <-chan watchdirs.FileChangedEvent,
 	done chan<- bool,
 ) {
@@ -57,8 +89,14 @@ func StartChildProcess(
 	l := deps.Logger()
 	st := state{
 		locker:             &sync.Mutex{},
+		now:                deps.Now,
 		minRestartInterval: 5 * time.Second,
 	}
+	if c, err := buildcache.Open(buildcache.FileName); err != nil {
+		l.Errorf(logger.WARNING, "Build cache disabled: %s", err)
+	} else {
+		st.cache = c
+	}
 
 	func() {
 		st.locker.Lock()
@@ -68,8 +106,18 @@ func StartChildProcess(
 		}
 	}()
 
-	for range fileChangedChan {
-		handleEvent(l, cfg, &st)
+loop:
+	for {
+		select {
+		case ev, ok := <-fileChangedChan:
+			if !ok {
+				break loop
+			}
+			handleEvent(l, cfg, &st, ev)
+		case next := <-configUpdates:
+			handleConfigUpdate(l, cfg, next, &st)
+			cfg = next
+		}
 	}
 
 	func() {
@@ -85,17 +133,16 @@ func handleEvent(
 	l logger.Logger,
 	cfg runtimeconfig.Config,
 	st *state,
+	ev watchdirs.FileChangedEvent,
 ) {
 	st.locker.Lock()
 	defer st.locker.Unlock()
-	elapsed := time.Since(st.lastRestartAt)
+	if !slices.Contains(st.changedFiles, ev.Path) {
+		st.changedFiles = append(st.changedFiles, ev.Path)
+	}
+	elapsed := st.now().Sub(st.lastRestartAt)
 	if elapsed > st.minRestartInterval {
-		if err := stopChildProcess(l, cfg, st); err != nil {
-			l.Errorf(logger.DEBUG, "Error stopping current child process: %s", err)
-		} else if err := startChildProcess(l, cfg, st); err != nil {
-			l.Errorf(logger.DEBUG, "Error starting new child process: %s", err)
-		}
-		st.lastRestartAt = time.Now()
+		restartChildProcess(l, cfg, cfg, st)
 	} else {
 		l.Errorf(
 			logger.DEBUG,
@@ -106,6 +153,57 @@ func handleEvent(
 	}
 }
 
+// handleConfigUpdate restarts the child process when the server process
+// settings of the new configuration next differ from those of prev.
+func handleConfigUpdate(
+	l logger.Logger,
+	prev runtimeconfig.Config,
+	next runtimeconfig.Config,
+	st *state,
+) {
+	st.locker.Lock()
+	defer st.locker.Unlock()
+	if runtimeconfig.Compare(prev, next).Process {
+		l.Errorf(logger.INFO, "Server process settings changed, restarting")
+		restartChildProcess(l, prev, next, st)
+	}
+}
+
+// restartChildProcess replaces the child process with one started from
+// the configuration cfg, following its restart strategy. The current
+// child process was started from the configuration prev, which
+// determines how it is stopped.
+//
+// The caller should manage locking and unlocking the mutex carried by
+// the state object st.
+func restartChildProcess(
+	l logger.Logger,
+	prev runtimeconfig.Config,
+	cfg runtimeconfig.Config,
+	st *state,
+) {
+	restartCount := st.restartCount
+	switch cfg.RestartStrategy() {
+	case runtimeconfig.StartThenStop:
+		if err := rotateChildProcess(l, prev, cfg, st); err != nil {
+			l.Errorf(logger.ERROR, "Error rotating child process: %s", err)
+		}
+	default:
+		if err := stopChildProcess(l, prev, st); err != nil {
+			l.Errorf(logger.DEBUG, "Error stopping current child process: %s", err)
+		}
+		if err := startChildProcess(l, cfg, st); err != nil {
+			l.Errorf(logger.DEBUG, "Error starting new child process: %s", err)
+		}
+	}
+	st.lastRestartAt = st.now()
+	// The changes are kept for the next restart when no new process
+	// started, so that routed preamble commands that failed run again.
+	if st.restartCount != restartCount {
+		st.changedFiles = nil
+	}
+}
+
 // stopChildProcess stops the child process.
 //
 // The caller should manage locking and unlocking the mutex carried by
@@ -114,26 +212,12 @@ func stopChildProcess(l logger.Logger, cfg runtimeconfig.Config, st *state) erro
 	if st.currentProcState == procStateStarted {
 		l.Errorf(logger.INFO, "Stopping child process")
 		st.currentProcState = procStateStopping
-		shutdownStaredAt := time.Now()
-		success := func() error {
-			st.currentProcState = procStateNotStarted
-			l.Errorf(logger.DEBUG, "Child process quit in %s", time.Since(shutdownStaredAt))
-			return nil
-		}
-		isSigint := cfg.QuitSignal() == syscall.SIGINT
-		if err := st.cmd.Process.Signal(cfg.QuitSignal()); err != nil {
-			return fmt.Errorf("sending signal to child process: %w", err)
-		} else if st.cmd == nil {
-			return errors.New("child process should not be nil")
-		} else if err := st.cmd.Wait(); err != nil {
-			if isSigint && strings.Contains(err.Error(), "signal: interrupt") {
-				l.Errorf(logger.DEBUG, "Child process quit with SIGINT")
-				return success()
-			}
-			return fmt.Errorf("waiting for child process to finish: %w", err)
-		} else {
-			return success()
-		}
+		err := stopServerProcess(l, cfg, st.proc)
+		// The process has exited even when it did not stop cleanly, so
+		// that a new one can start.
+		st.proc = nil
+		st.currentProcState = procStateNotStarted
+		return err
 	} else if st.currentProcState == procStateNotStarted {
 		l.Errorf(logger.WARNING, "Current process state: not started")
 		return nil
@@ -155,36 +239,176 @@ func startChildProcess(
 		return fmt.Errorf("invalid state before start: %s", st.currentProcState.String())
 	}
 	st.currentProcState = procStateStarting
-	for _, c := range cfg.PreambleCommands() {
-		if err := runPreambleCommand(c); err != nil {
-			l.Errorf(logger.ERROR, "Error running preamble command: %s", err)
-			st.lastRestartAt = time.Now()
-			st.currentProcState = procStateNotStarted
-			return nil
-		}
+	cyc, err := command.NewCycle(l, cfg, st.restartCount, st.changedFiles)
+	if err != nil {
+		st.currentProcState = procStateNotStarted
+		return err
+	}
+	defer cyc.Close()
+	if err := runPreambleCommands(l, cfg, st, cyc); err != nil {
+		l.Errorf(logger.ERROR, "Error running preamble command: %s", err)
+		st.lastRestartAt = st.now()
+		st.currentProcState = procStateNotStarted
+		return nil
+	}
+	if p, err := startServerProcess(cfg.ServerCommand(), cyc.Env); err != nil {
+		st.currentProcState = procStateNotStarted
+		return err
+	} else {
+		st.proc = p
+		st.lastRestartAt = st.now()
+		st.restartCount++
+		st.currentProcState = procStateStarted
+		return nil
 	}
-	if cmd, err := runServerCommand(cfg.ServerCommand()); err != nil {
+}
+
+// rotateChildProcess starts a new child process while the current one
+// keeps running, and stops the current one only after the new one is
+// ready. If the new process fails to start or become ready, the current
+// process is left running. The new process is started from cfg and the
+// current one is stopped according to prev.
+//
+// The caller should manage locking and unlocking the mutex carried by
+// the state object st.
+func rotateChildProcess(
+	l logger.Logger,
+	prev runtimeconfig.Config,
+	cfg runtimeconfig.Config,
+	st *state,
+) error {
+	if st.currentProcState == procStateNotStarted {
+		return startChildProcess(l, cfg, st)
+	} else if st.currentProcState != procStateStarted {
+		return fmt.Errorf("invalid state before rotation: %s", st.currentProcState.String())
+	}
+	st.currentProcState = procStateRotating
+	cyc, err := command.NewCycle(l, cfg, st.restartCount, st.changedFiles)
+	if err != nil {
+		st.currentProcState = procStateStarted
+		return err
+	}
+	defer cyc.Close()
+	if err := runPreambleCommands(l, cfg, st, cyc); err != nil {
+		st.currentProcState = procStateStarted
+		return fmt.Errorf("running preamble command: %w", err)
+	}
+	l.Errorf(logger.INFO, "Starting new child process")
+	if p, err := startServerProcess(cfg.ServerCommand(), cyc.Env); err != nil {
+		st.currentProcState = procStateStarted
 		return err
 	} else {
-		st.cmd = cmd
-		st.lastRestartAt = time.Now()
+		st.nextProc = p
+	}
+	if err := waitForReadiness(l, cfg, st.nextProc, cyc); err != nil {
+		l.Errorf(logger.WARNING, "New child process is not ready, keeping the current one: %s", err)
+		if err := stopServerProcess(l, cfg, st.nextProc); err != nil {
+			l.Errorf(logger.DEBUG, "Error stopping new child process: %s", err)
+		}
+		st.nextProc = nil
 		st.currentProcState = procStateStarted
 		return nil
 	}
+	l.Errorf(logger.INFO, "Stopping previous child process")
+	if err := stopServerProcess(l, prev, st.proc); err != nil {
+		l.Errorf(logger.DEBUG, "Error stopping previous child process: %s", err)
+	}
+	st.proc = st.nextProc
+	st.nextProc = nil
+	st.lastRestartAt = st.now()
+	st.restartCount++
+	st.currentProcState = procStateStarted
+	return nil
 }
 
-func runPreambleCommand(c string) error {
-	commandParts := strings.Split(c, " ")
-	name := commandParts[0]
-	args := commandParts[1:]
-	for i, a := range args {
-		args[i] = os.ExpandEnv(a)
+// waitForReadiness blocks until the server process p is considered
+// ready. Without a readiness command, the process is ready once it has
+// stayed up for a short grace period. Otherwise the readiness command is
+// retried until it succeeds or the readiness timeout elapses.
+func waitForReadiness(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	p *serverProcess,
+	cyc *command.Cycle,
+) error {
+	if cfg.ReadinessCommand() == "" {
+		select {
+		case <-p.exited:
+			return fmt.Errorf("process exited early: %v", p.err)
+		case <-time.After(readinessGracePeriod):
+			return nil
+		}
 	}
+	deadline := time.Now().Add(cfg.ReadinessTimeout())
+	for {
+		select {
+		case <-p.exited:
+			return fmt.Errorf("process exited early: %v", p.err)
+		default:
+		}
+		if err := runPreambleCommand(cfg.ReadinessCommand(), cyc); err == nil {
+			l.Errorf(logger.DEBUG, "Readiness command succeeded")
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("readiness command did not succeed within %s: %w", cfg.ReadinessTimeout(), err)
+		}
+		select {
+		case <-p.exited:
+			return fmt.Errorf("process exited early: %v", p.err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// stopServerProcess sends the configured quit signal to the server
+// process p and waits for it to exit.
+func stopServerProcess(l logger.Logger, cfg runtimeconfig.Config, p *serverProcess) error {
+	if p == nil {
+		return errors.New("child process should not be nil")
+	}
+	shutdownStaredAt := time.Now()
+	select {
+	case <-p.exited:
+	default:
+		if err := p.cmd.Process.Signal(cfg.QuitSignal()); err != nil {
+			return fmt.Errorf("sending signal to child process: %w", err)
+		}
+		<-p.exited
+	}
+	if p.err != nil {
+		// Exiting on the quit signal is a clean stop.
+		if ws, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == cfg.QuitSignal() {
+			l.Errorf(logger.DEBUG, "Child process quit with %s", cfg.QuitSignal())
+		} else {
+			return fmt.Errorf("waiting for child process to finish: %w", p.err)
+		}
+	}
+	l.Errorf(logger.DEBUG, "Child process quit in %s", time.Since(shutdownStaredAt))
+	return nil
+}
+
+// runPreambleCommands runs the preamble commands whose file patterns
+// match the files that triggered the restart.
+func runPreambleCommands(l logger.Logger, cfg runtimeconfig.Config, st *state, cyc *command.Cycle) error {
+	return preamble.Run(
+		l,
+		cfg.Preambles(),
+		cyc.ChangedFiles,
+		cfg.Roots(),
+		st.cache,
+		func(p runtimeconfig.Preamble) error {
+			return runPreambleCommand(p.Command, cyc)
+		},
+	)
+}
+
+func runPreambleCommand(c string, cyc *command.Cycle) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	proc := exec.CommandContext(ctx, name, args...)
-	proc.Stdout = os.Stdout
-	proc.Stderr = os.Stderr
+	proc, err := cyc.Command(ctx, c)
+	if err != nil {
+		return err
+	}
 	if err := proc.Start(); err != nil {
 		return fmt.Errorf("starting preamble command: %w", err)
 	}
@@ -194,14 +418,31 @@ func runPreambleCommand(c string) error {
 	return nil
 }
 
-func runServerCommand(c string) (*exec.Cmd, error) {
-	commandParts := strings.Split(c, " ")
-	name := commandParts[0]
-	args := commandParts[1:]
-	for i, a := range args {
-		args[i] = os.ExpandEnv(a)
+// startServerProcess starts the server command and begins waiting for it
+// to exit in the background.
+func startServerProcess(c string, env map[string]string) (*serverProcess, error) {
+	if cmd, err := runServerCommand(c, env); err != nil {
+		return nil, err
+	} else {
+		p := &serverProcess{
+			cmd:    cmd,
+			exited: make(chan struct{}),
+		}
+		go func() {
+			p.err = cmd.Wait()
+			close(p.exited)
+		}()
+		return p, nil
+	}
+}
+
+func runServerCommand(c string, env map[string]string) (*exec.Cmd, error) {
+	name, args, err := command.Split(c, env)
+	if err != nil {
+		return nil, err
 	}
 	proc := exec.Command(name, args...)
+	proc.Env = command.EnvList(env)
 	proc.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
@@ -0,0 +1,137 @@
+// Package command holds helpers shared by the components that run
+// configured commands.
+package command
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Split parses the command line c into the program name and its
+// arguments. Like in a shell, arguments are separated by whitespace
+// except within single or double quotes, and a backslash outside single
+// quotes escapes the next character. References to $VAR or ${VAR}
+// outside single quotes are expanded using env.
+func Split(c string, env map[string]string) (string, []string, error) {
+	var (
+		result  []string
+		current strings.Builder
+		// inArg is set once the current argument has started, so that
+		// quoted empty arguments are kept.
+		inArg bool
+		quote rune
+	)
+	runes := []rune(c)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && (quote == 0 || (i+1 < len(runes) && strings.ContainsRune(`"\$`, runes[i+1]))):
+			if i+1 >= len(runes) {
+				return "", nil, fmt.Errorf("command ends with a backslash: %q", c)
+			}
+			i++
+			current.WriteRune(runes[i])
+			inArg = true
+		case r == '$':
+			name, n := varName(runes[i+1:])
+			if n == 0 {
+				current.WriteRune(r)
+			} else {
+				current.WriteString(env[name])
+				i += n
+			}
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				result = append(result, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return "", nil, fmt.Errorf("command has an unterminated %c quote: %q", quote, c)
+	}
+	if inArg {
+		result = append(result, current.String())
+	}
+	if len(result) < 1 {
+		return "", nil, fmt.Errorf("command is empty: %q", c)
+	}
+	return result[0], result[1:], nil
+}
+
+// varName returns the name of the variable referenced by the runes
+// following a $, along with the number of runes of the reference, which
+// is 0 when they do not reference a variable.
+func varName(runes []rune) (string, int) {
+	isNameRune := func(r rune) bool {
+		return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+	}
+	if len(runes) > 0 && runes[0] == '{' {
+		for i, r := range runes[1:] {
+			if r == '}' {
+				return string(runes[1 : i+1]), i + 2
+			}
+		}
+		return "", 0
+	}
+	n := 0
+	for n < len(runes) && isNameRune(runes[n]) {
+		n++
+	}
+	return string(runes[:n]), n
+}
+
+// Quote returns s quoted for Split when it contains whitespace, quotes,
+// backslashes or references to variables, and s itself otherwise.
+func Quote(s string) string {
+	if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`'"\$`, r)
+	}) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Environ returns the environment of the current process as a map.
+func Environ() map[string]string {
+	result := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, found := strings.Cut(kv, "="); found {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// EnvList converts env to the KEY=VALUE form expected by exec.Cmd.
+func EnvList(env map[string]string) []string {
+	result := make([]string, 0, len(env))
+	for k, v := range env {
+		result = append(result, k+"="+v)
+	}
+	slices.Sort(result)
+	return result
+}
@@ -0,0 +1,79 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	type testConfig struct {
+		desc         string
+		command      string
+		expectedName string
+		expectedArgs []string
+		expectedErr  string
+	}
+	env := map[string]string{"NAME": "a b", "EMPTY": ""}
+	testConfigs := []testConfig{
+		{
+			desc:         "whitespace",
+			command:      "  go   test\t./... ",
+			expectedName: "go",
+			expectedArgs: []string{"test", "./..."},
+		},
+		{
+			desc:         "quotes",
+			command:      `go test -run 'A B' "C D" ''`,
+			expectedName: "go",
+			expectedArgs: []string{"test", "-run", "A B", "C D", ""},
+		},
+		{
+			desc:         "escapes",
+			command:      `echo a\ b "\"\$NAME\\" '\n'`,
+			expectedName: "echo",
+			expectedArgs: []string{"a b", `"$NAME\`, `\n`},
+		},
+		{
+			desc:         "variables",
+			command:      `echo $NAME ${NAME}x "$NAME" '$NAME' $EMPTY $ $MISSING`,
+			expectedName: "echo",
+			expectedArgs: []string{"a b", "a bx", "a b", "$NAME", "", "$", ""},
+		},
+		{
+			desc:        "empty",
+			command:     " ",
+			expectedErr: `command is empty: " "`,
+		},
+		{
+			desc:        "unterminated quote",
+			command:     `echo 'a`,
+			expectedErr: `command has an unterminated ' quote: "echo 'a"`,
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(
+			cfg.desc,
+			func(t *testing.T) {
+				name, args, err := command.Split(cfg.command, env)
+				if cfg.expectedErr != "" {
+					assert.EqualError(t, err, cfg.expectedErr)
+				} else if assert.NoError(t, err) {
+					assert.Equal(t, cfg.expectedName, name)
+					assert.Equal(t, cfg.expectedArgs, args)
+				}
+			},
+		)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"plain.go", "", "a b.go", "it's.go", `a"b`, `a\b`, "$HOME.go"} {
+		_, args, err := command.Split("echo "+command.Quote(s), nil)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, []string{s}, args)
+		}
+	}
+	assert.Equal(t, "plain.go", command.Quote("plain.go"))
+}
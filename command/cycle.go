@@ -0,0 +1,154 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jakewan/go-procrotator/gopackages"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+// templateData is the data available to command templates, e.g.
+// "golangci-lint run {{.ChangedFiles}}".
+type templateData struct {
+	// ChangedFiles is the space-separated list of files that triggered
+	// the cycle, quoted as needed.
+	ChangedFiles string
+	// ChangedFilesFile is the path of a temporary file listing the
+	// changed files, one per line.
+	ChangedFilesFile string
+	// RestartCount is the number of restarts so far.
+	RestartCount int
+	// ChangedPackages and AffectedPackages are the space-separated
+	// import paths of the Go packages containing the changed files and
+	// of those packages along with the packages importing them. Set with
+	// the go preset only.
+	ChangedPackages  string
+	AffectedPackages string
+}
+
+// Cycle holds what the commands run in response to one batch of changes
+// have in common.
+type Cycle struct {
+	Env          map[string]string
+	ChangedFiles []string
+	data         templateData
+	listFile     string
+}
+
+// NewCycle prepares the environment and template data for the commands
+// run in response to changedFiles. The caller must call Close when the
+// cycle completes.
+func NewCycle(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	restartCount int,
+	changedFiles []string,
+) (*Cycle, error) {
+	env, err := Environment(cfg, restartCount, changedFiles)
+	if err != nil {
+		return nil, fmt.Errorf("building command environment: %w", err)
+	}
+	f, err := os.CreateTemp("", "procrotator-changed-files-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("creating changed files list: %w", err)
+	}
+	defer f.Close()
+	for _, p := range changedFiles {
+		if _, err := fmt.Fprintln(f, p); err != nil {
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("writing changed files list: %w", err)
+		}
+	}
+	env[EnvChangedFilesFile] = f.Name()
+	c := &Cycle{
+		Env:          env,
+		ChangedFiles: changedFiles,
+		data: templateData{
+			ChangedFiles:     quoteAll(changedFiles),
+			ChangedFilesFile: f.Name(),
+			RestartCount:     restartCount,
+		},
+		listFile: f.Name(),
+	}
+	if cfg.Preset() == runtimeconfig.GoPreset {
+		if err := c.resolveGoPackages(); err != nil {
+			l.Errorf(logger.WARNING, "Error resolving affected Go packages: %s", err)
+		}
+	}
+	return c, nil
+}
+
+// quoteAll joins paths with spaces, quoting those that need it.
+func quoteAll(paths []string) string {
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, Quote(p))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// resolveGoPackages maps the changed files to Go packages. On the
+// initial cycle, when no files have changed, every package of the main
+// module counts as changed.
+func (c *Cycle) resolveGoPackages() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	g, err := gopackages.Load(ctx, ".")
+	if err != nil {
+		return err
+	}
+	changed := g.Packages()
+	if len(c.ChangedFiles) > 0 {
+		changed = g.ChangedPackages(c.ChangedFiles)
+	}
+	affected := g.AffectedPackages(changed)
+	c.data.ChangedPackages = strings.Join(changed, " ")
+	c.data.AffectedPackages = strings.Join(affected, " ")
+	c.Env[EnvChangedPackages] = c.data.ChangedPackages
+	c.Env[EnvAffectedPackages] = c.data.AffectedPackages
+	return nil
+}
+
+// Close removes the temporary files of the cycle.
+func (c *Cycle) Close() {
+	os.Remove(c.listFile)
+}
+
+// Expand executes the command template command.
+func (c *Cycle) Expand(command string) (string, error) {
+	if t, err := template.New("command").Option("missingkey=error").Parse(command); err != nil {
+		return "", fmt.Errorf("parsing command template: %w", err)
+	} else {
+		var b strings.Builder
+		if err := t.Execute(&b, c.data); err != nil {
+			return "", fmt.Errorf("executing command template: %w", err)
+		}
+		return b.String(), nil
+	}
+}
+
+// Command expands the command template c and prepares it to run with the
+// environment of the cycle, sharing the standard output and error of
+// go-procrotator.
+func (c *Cycle) Command(ctx context.Context, command string) (*exec.Cmd, error) {
+	expanded, err := c.Expand(command)
+	if err != nil {
+		return nil, err
+	}
+	name, args, err := Split(expanded, c.Env)
+	if err != nil {
+		return nil, err
+	}
+	proc := exec.CommandContext(ctx, name, args...)
+	proc.Env = EnvList(c.Env)
+	proc.Stdout = os.Stdout
+	proc.Stderr = os.Stderr
+	return proc, nil
+}
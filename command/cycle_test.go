@@ -0,0 +1,49 @@
+package command_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCycle(t *testing.T) {
+	cfg := buildConfig(t, `server_command = "./app"`, map[string]string{})
+	changedFiles := []string{"/app/main.go", "/app/my file.go"}
+	c, err := command.NewCycle(logger.NewLogger("test", io.Discard), cfg, 2, changedFiles)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	// The changed files are listed in a file, one per line.
+	listFile := c.Env[command.EnvChangedFilesFile]
+	if b, err := os.ReadFile(listFile); assert.NoError(t, err) {
+		assert.Equal(t, "/app/main.go\n/app/my file.go\n", string(b))
+	}
+	assert.Equal(t, "2", c.Env[command.EnvRestartCount])
+
+	// Templates expand to the changed files, quoted as needed.
+	expanded, err := c.Expand("lint {{.ChangedFiles}} --count {{.RestartCount}} --list {{.ChangedFilesFile}}")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "lint /app/main.go '/app/my file.go' --count 2 --list "+listFile, expanded)
+	}
+	_, err = c.Expand("{{.Unknown}}")
+	assert.Error(t, err)
+
+	// Commands receive each changed file as a single argument.
+	proc, err := c.Command(context.Background(), "lint {{.ChangedFiles}}")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"lint", "/app/main.go", "/app/my file.go"}, proc.Args)
+		assert.Contains(t, proc.Env, command.EnvRestartCount+"=2")
+	}
+
+	// Closing the cycle removes the list file.
+	c.Close()
+	_, err = os.Stat(listFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,49 @@
+package command
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jakewan/go-procrotator/dotenv"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+const (
+	EnvRestartCount     = "PROCROTATOR_RESTART_COUNT"
+	EnvChangedFiles     = "PROCROTATOR_CHANGED_FILES"
+	EnvChangedFilesFile = "PROCROTATOR_CHANGED_FILES_FILE"
+	EnvChangedPackages  = "PROCROTATOR_CHANGED_PACKAGES"
+	EnvAffectedPackages = "PROCROTATOR_AFFECTED_PACKAGES"
+)
+
+// Environment builds the environment for configured commands. Starting
+// from the inherited environment (or an empty one when clear_env is
+// set), it applies the env files in order, then the env table, then the
+// variables injected by go-procrotator.
+//
+// The env files are read on every call so edits take effect on the next
+// restart.
+func Environment(
+	cfg runtimeconfig.Config,
+	restartCount int,
+	changedFiles []string,
+) (map[string]string, error) {
+	result := map[string]string{}
+	if !cfg.ClearEnv() {
+		result = Environ()
+	}
+	if fileVars, err := dotenv.ReadFiles(cfg.EnvFiles()...); err != nil {
+		return nil, err
+	} else {
+		for k, v := range fileVars {
+			result[k] = v
+		}
+	}
+	for k, v := range cfg.Env() {
+		result[k] = v
+	}
+	result[EnvRestartCount] = strconv.Itoa(restartCount)
+	result[EnvChangedFiles] = strings.Join(changedFiles, string(os.PathListSeparator))
+	return result, nil
+}
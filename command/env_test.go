@@ -0,0 +1,115 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildConfig builds the configuration of the config file content in a
+// new directory, along with the files, named relative to the directory.
+// "{{dir}}" in content stands for the directory.
+func buildConfig(t *testing.T, content string, files map[string]string) runtimeconfig.Config {
+	t.Helper()
+	d := t.TempDir()
+	files["procrotator.toml"] = strings.ReplaceAll(content, "{{dir}}", d)
+	for name, c := range files {
+		if err := os.WriteFile(filepath.Join(d, name), []byte(c), 0644); err != nil {
+			assert.FailNow(t, "Error writing file", err)
+		}
+	}
+	cfg, err := runtimeconfig.Build([]string{"-d", d, "-config", filepath.Join(d, "procrotator.toml")})
+	if err != nil {
+		assert.FailNow(t, "Error building config", err)
+	}
+	return cfg
+}
+
+func TestEnvironment(t *testing.T) {
+	t.Setenv("PROCROTATOR_TEST_INHERITED", "inherited")
+	t.Setenv("PROCROTATOR_TEST_OVERRIDDEN", "inherited")
+	type testConfig struct {
+		desc     string
+		content  string
+		expected map[string]string
+		absent   []string
+	}
+	testConfigs := []testConfig{
+		{
+			desc:    "inherited environment",
+			content: `server_command = "./app"`,
+			expected: map[string]string{
+				"PROCROTATOR_TEST_INHERITED":  "inherited",
+				"PROCROTATOR_TEST_OVERRIDDEN": "inherited",
+				command.EnvRestartCount:       "3",
+				command.EnvChangedFiles:       "/app/a.go" + string(os.PathListSeparator) + "/app/b c.go",
+			},
+		},
+		{
+			desc: "env files in order, then the env table",
+			content: `server_command = "./app"
+env_file = ["{{dir}}/first.env", "{{dir}}/second.env", "{{dir}}/missing.env"]
+[env]
+FROM_TABLE = "table"
+`,
+			expected: map[string]string{
+				"PROCROTATOR_TEST_INHERITED":  "inherited",
+				"PROCROTATOR_TEST_OVERRIDDEN": "second",
+				"FROM_FIRST":                  "first",
+				"FROM_TABLE":                  "table",
+			},
+		},
+		{
+			desc: "cleared environment",
+			content: `server_command = "./app"
+clear_env = true
+env_file = ["{{dir}}/first.env"]
+`,
+			expected: map[string]string{
+				"PROCROTATOR_TEST_OVERRIDDEN": "first",
+				"FROM_FIRST":                  "first",
+				"FROM_TABLE":                  "first",
+			},
+			absent: []string{"PROCROTATOR_TEST_INHERITED"},
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(
+			cfg.desc,
+			func(t *testing.T) {
+				// Setup
+				files := map[string]string{
+					"first.env":  "FROM_FIRST=first\nFROM_TABLE=first\nPROCROTATOR_TEST_OVERRIDDEN=first\n",
+					"second.env": "FROM_TABLE=second\nPROCROTATOR_TEST_OVERRIDDEN=second\n",
+				}
+				c := buildConfig(t, cfg.content, files)
+
+				// Code under test
+				env, err := command.Environment(c, 3, []string{"/app/a.go", "/app/b c.go"})
+
+				// Validate
+				if assert.NoError(t, err) {
+					for k, v := range cfg.expected {
+						assert.Equal(t, v, env[k], k)
+					}
+					for _, k := range cfg.absent {
+						assert.NotContains(t, env, k)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestEnvironmentInvalidEnvFile(t *testing.T) {
+	c := buildConfig(t, "server_command = \"./app\"\nenv_file = [\"{{dir}}/bad.env\"]", map[string]string{
+		"bad.env": "NOT A DEFINITION\n",
+	})
+	_, err := command.Environment(c, 0, nil)
+	assert.Error(t, err)
+}
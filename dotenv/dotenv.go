@@ -0,0 +1,113 @@
+// Package dotenv reads environment variable definitions from dotenv
+// files.
+package dotenv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Parse reads KEY=VALUE definitions from r. Blank lines and lines
+// starting with # are ignored, and an optional leading "export" keyword
+// is accepted. Values may be wrapped in single quotes (taken literally)
+// or double quotes (supporting \n, \t, \" and \\ escapes). Unquoted values
+// end at the first " #".
+func Parse(r io.Reader) (map[string]string, error) {
+	result := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", lineNumber, key)
+		}
+		if v, err := parseValue(strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		} else {
+			result[key] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReadFiles parses each of the named files in order, with definitions in
+// later files taking precedence. Files that do not exist are skipped.
+func ReadFiles(paths ...string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		vars, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", p, err)
+		}
+		for k, v := range vars {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func parseValue(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '\'':
+		if end := strings.IndexByte(s[1:], '\''); end < 0 {
+			return "", errors.New("unterminated single-quoted value")
+		} else {
+			return s[1 : end+1], nil
+		}
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			c := s[i]
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(s):
+				i++
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(s[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double-quoted value")
+	default:
+		if i := strings.Index(s, " #"); i > -1 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s), nil
+	}
+}
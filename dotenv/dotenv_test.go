@@ -0,0 +1,60 @@
+package dotenv_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/dotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	vars, err := dotenv.Parse(strings.NewReader(`
+# A comment
+PLAIN=value
+export EXPORTED=yes
+SPACED = padded   # trailing comment
+SINGLE='$NOT_EXPANDED # kept'
+DOUBLE="line one\nline \"two\""
+EMPTY=
+`))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		map[string]string{
+			"PLAIN":    "value",
+			"EXPORTED": "yes",
+			"SPACED":   "padded",
+			"SINGLE":   "$NOT_EXPANDED # kept",
+			"DOUBLE":   "line one\nline \"two\"",
+			"EMPTY":    "",
+		},
+		vars,
+	)
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := dotenv.Parse(strings.NewReader("OK=1\nNOT A DEFINITION\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = dotenv.Parse(strings.NewReader(`QUOTED="unterminated`))
+	assert.ErrorContains(t, err, "unterminated")
+}
+
+func TestReadFiles(t *testing.T) {
+	d := t.TempDir()
+	if err := os.WriteFile(filepath.Join(d, ".env"), []byte("A=1\nB=1\n"), 0666); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(d, ".env.local"), []byte("B=2\n"), 0666); err != nil {
+		panic(err)
+	}
+	vars, err := dotenv.ReadFiles(
+		filepath.Join(d, ".env"),
+		filepath.Join(d, ".env.missing"),
+		filepath.Join(d, ".env.local"),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "1", "B": "2"}, vars)
+}
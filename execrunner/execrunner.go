@@ -0,0 +1,181 @@
+// Package execrunner runs a command to completion on every change, as an
+// alternative to the long-lived server process managed by childproc.
+package execrunner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/jakewan/go-procrotator/buildcache"
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/preamble"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+type Dependencies interface {
+	Logger() logger.Logger
+}
+
+const (
+	// quietPeriod is how long the runner waits for further changes
+	// before starting a run, so that a burst of changes starts one run.
+	quietPeriod = 100 * time.Millisecond
+	// stopTimeout is how long a cancelled run may take to exit after
+	// receiving the quit signal before it is killed.
+	stopTimeout = 5 * time.Second
+)
+
+type state struct {
+	runCount  int
+	cancelRun context.CancelFunc
+	runDone   chan struct{}
+	cache     *buildcache.Cache
+}
+
+// StartExecRunner runs the exec command once at startup and again after
+// every batch of changes received from fileChangedChan. A run still in
+// progress when a change arrives is cancelled. Configurations received
+// from configUpdates apply to subsequent runs, and start a new run right
+// away when the exec command or its environment changed.
+//
+// The runner returns after fileChangedChan is closed and the current run
+// has been cancelled.
+func StartExecRunner(
+	deps Dependencies,
+	cfg runtimeconfig.Config,
+	configUpdates <-chan runtimeconfig.Config,
+	fileChangedChan <-chan watchdirs.FileChangedEvent,
+	done chan<- bool,
+) {
+	defer func() {
+		done <- true
+	}()
+	l := deps.Logger()
+	st := state{}
+	if c, err := buildcache.Open(buildcache.FileName); err != nil {
+		l.Errorf(logger.WARNING, "Build cache disabled: %s", err)
+	} else {
+		st.cache = c
+	}
+
+	startRun(l, cfg, &st, nil)
+	var (
+		changedFiles []string
+		quiet        <-chan time.Time
+	)
+	for {
+		select {
+		case ev, ok := <-fileChangedChan:
+			if !ok {
+				stopRun(&st)
+				return
+			}
+			if st.cancelRun != nil {
+				st.cancelRun()
+			}
+			if !slices.Contains(changedFiles, ev.Path) {
+				changedFiles = append(changedFiles, ev.Path)
+			}
+			quiet = time.After(quietPeriod)
+		case <-quiet:
+			quiet = nil
+			stopRun(&st)
+			startRun(l, cfg, &st, changedFiles)
+			changedFiles = nil
+		case next := <-configUpdates:
+			restart := runtimeconfig.Compare(cfg, next).Process
+			cfg = next
+			if restart && quiet == nil {
+				l.Errorf(logger.INFO, "Exec command settings changed, running again")
+				stopRun(&st)
+				startRun(l, cfg, &st, nil)
+			}
+		}
+	}
+}
+
+func startRun(l logger.Logger, cfg runtimeconfig.Config, st *state, changedFiles []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	st.cancelRun = cancel
+	st.runDone = make(chan struct{})
+	go func(runCount int, runDone chan<- struct{}) {
+		defer close(runDone)
+		run(ctx, l, cfg, st.cache, runCount, changedFiles)
+	}(st.runCount, st.runDone)
+	st.runCount++
+}
+
+// stopRun cancels the current run, if any, and waits for it to exit.
+func stopRun(st *state) {
+	if st.cancelRun != nil {
+		st.cancelRun()
+		<-st.runDone
+		st.cancelRun = nil
+	}
+}
+
+func run(
+	ctx context.Context,
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	cache *buildcache.Cache,
+	runCount int,
+	changedFiles []string,
+) {
+	cyc, err := command.NewCycle(l, cfg, runCount, changedFiles)
+	if err != nil {
+		l.Errorf(logger.ERROR, "Error preparing run: %s", err)
+		return
+	}
+	defer cyc.Close()
+	startedAt := time.Now()
+	if err := preamble.Run(
+		l,
+		cfg.Preambles(),
+		changedFiles,
+		cfg.Roots(),
+		cache,
+		func(p runtimeconfig.Preamble) error {
+			if proc, err := cyc.Command(ctx, p.Command); err != nil {
+				return err
+			} else {
+				return proc.Run()
+			}
+		},
+	); err != nil {
+		reportResult(ctx, l, err, time.Since(startedAt))
+		return
+	}
+	proc, err := cyc.Command(ctx, cfg.ExecCommand())
+	if err != nil {
+		l.Errorf(logger.ERROR, "Error preparing exec command: %s", err)
+		return
+	}
+	// Run the command in its own process group and signal the whole group
+	// on cancellation so that processes it starts also stop.
+	proc.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+	proc.Cancel = func() error {
+		return syscall.Kill(-proc.Process.Pid, cfg.QuitSignal())
+	}
+	proc.WaitDelay = stopTimeout
+	l.Errorf(logger.DEBUG, "Running exec command: %s", cfg.ExecCommand())
+	reportResult(ctx, l, proc.Run(), time.Since(startedAt))
+}
+
+func reportResult(ctx context.Context, l logger.Logger, err error, elapsed time.Duration) {
+	elapsed = elapsed.Round(time.Millisecond)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		l.Errorf(logger.WARNING, "CANCELLED after %s", elapsed)
+	} else if err != nil {
+		l.Errorf(logger.ERROR, "FAIL in %s: %s", elapsed, err)
+	} else {
+		l.Errorf(logger.NOTICE, "PASS in %s", elapsed)
+	}
+}
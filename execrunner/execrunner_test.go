@@ -0,0 +1,115 @@
+package execrunner_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/execrunner"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDeps keeps the log for the test to wait on.
+type testDeps struct {
+	mu     sync.Mutex
+	log    strings.Builder
+	logger logger.Logger
+}
+
+func newTestDeps() *testDeps {
+	d := &testDeps{}
+	d.logger = logger.NewLogger("test", d)
+	return d
+}
+
+// Logger implements execrunner.Dependencies.
+func (d *testDeps) Logger() logger.Logger {
+	return d.logger
+}
+
+// Write implements io.Writer for the logger.
+func (d *testDeps) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.log.Write(b)
+}
+
+// logCount returns how many times s was logged.
+func (d *testDeps) logCount(s string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return strings.Count(d.log.String(), s)
+}
+
+func TestStartExecRunner(t *testing.T) {
+	tempDir := t.TempDir()
+	// The build cache is kept in the working directory.
+	t.Chdir(tempDir)
+	// Each run records its arguments and restart count. A run sleeps
+	// while the file slow exists, and fails while the file fail exists.
+	script := `echo "$* $PROCROTATOR_RESTART_COUNT" >> runs.log
+while [ -f slow ]; do sleep 0.01; done
+test ! -f fail
+`
+	assert.NoError(t, os.WriteFile("run.sh", []byte(script), 0o644))
+	buildConfig := func(execCommand string) runtimeconfig.Config {
+		cfg, err := runtimeconfig.Build([]string{
+			"-d", tempDir,
+			"-mode", "exec",
+			"-execcommand", execCommand,
+			"-i", `\.go$`,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return cfg
+	}
+	deps := newTestDeps()
+	configUpdates := make(chan runtimeconfig.Config)
+	fileChangedChan := make(chan watchdirs.FileChangedEvent)
+	done := make(chan bool, 1)
+	go execrunner.StartExecRunner(deps, buildConfig("sh run.sh first"), configUpdates, fileChangedChan, done)
+	waitForLog := func(s string, n int) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return deps.logCount(s) == n
+		}, 5*time.Second, 10*time.Millisecond, "%d of %q", n, s)
+	}
+	readRuns := func() []string {
+		b, _ := os.ReadFile("runs.log")
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	mainFile := filepath.Join(tempDir, "main.go")
+
+	// The initial run and runs after changes report their result.
+	waitForLog("PASS in", 1)
+	assert.NoError(t, os.WriteFile("fail", nil, 0o644))
+	fileChangedChan <- watchdirs.FileChangedEvent{Path: mainFile}
+	waitForLog("FAIL in", 1)
+	assert.NoError(t, os.Remove("fail"))
+
+	// A change arriving during a run cancels it and starts a new one.
+	assert.NoError(t, os.WriteFile("slow", nil, 0o644))
+	fileChangedChan <- watchdirs.FileChangedEvent{Path: mainFile}
+	assert.Eventually(t, func() bool {
+		return len(readRuns()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	fileChangedChan <- watchdirs.FileChangedEvent{Path: mainFile}
+	waitForLog("CANCELLED after", 1)
+	assert.NoError(t, os.Remove("slow"))
+	waitForLog("PASS in", 2)
+
+	// Changing the exec command runs it right away.
+	configUpdates <- buildConfig("sh run.sh second")
+	waitForLog("PASS in", 3)
+	assert.Equal(t, []string{"first 0", "first 1", "first 2", "first 3", "second 4"}, readRuns())
+
+	close(fileChangedChan)
+	<-done
+}
@@ -0,0 +1,141 @@
+// Package gopackages maps changed files of a Go module to the packages
+// they belong to and the packages that import them.
+package gopackages
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Imports    []string
+	// TestImports and XTestImports are the imports of the test files of
+	// the package and of its external test package.
+	TestImports  []string
+	XTestImports []string
+	Module       *struct {
+		Main bool
+	}
+}
+
+// Graph is the import graph of the packages of the main module.
+type Graph struct {
+	// byDir maps package directories to import paths.
+	byDir map[string]string
+	// importedBy maps import paths to the import paths of the main
+	// module packages importing them.
+	importedBy map[string][]string
+	// testedBy maps import paths to the import paths of the main module
+	// packages whose tests import them.
+	testedBy map[string][]string
+	packages []string
+}
+
+// Load builds the graph of the module in dir using
+// "go list -e -deps -json ./...".
+func Load(ctx context.Context, dir string) (*Graph, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-deps", "-json", "./...")
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running go list: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return Parse(&stdout)
+}
+
+// Parse builds a graph from the output of "go list -deps -json".
+func Parse(r io.Reader) (*Graph, error) {
+	g := &Graph{
+		byDir:      map[string]string{},
+		importedBy: map[string][]string{},
+		testedBy:   map[string][]string{},
+	}
+	decoder := json.NewDecoder(r)
+	for {
+		var p listedPackage
+		if err := decoder.Decode(&p); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decoding go list output: %w", err)
+		}
+		if p.Module == nil || !p.Module.Main {
+			continue
+		}
+		g.packages = append(g.packages, p.ImportPath)
+		if p.Dir != "" {
+			g.byDir[filepath.Clean(p.Dir)] = p.ImportPath
+		}
+		for _, i := range p.Imports {
+			g.importedBy[i] = append(g.importedBy[i], p.ImportPath)
+		}
+		for _, i := range slices.Concat(p.TestImports, p.XTestImports) {
+			if i != p.ImportPath && !slices.Contains(g.testedBy[i], p.ImportPath) {
+				g.testedBy[i] = append(g.testedBy[i], p.ImportPath)
+			}
+		}
+	}
+	slices.Sort(g.packages)
+	return g, nil
+}
+
+// Packages returns the import paths of all packages of the main module.
+func (g *Graph) Packages() []string {
+	return g.packages
+}
+
+// ChangedPackages returns the packages containing any of files, which
+// must be absolute paths. Files outside the packages of the main module
+// are ignored.
+func (g *Graph) ChangedPackages(files []string) []string {
+	var result []string
+	for _, f := range files {
+		if p, found := g.byDir[filepath.Dir(f)]; found && !slices.Contains(result, p) {
+			result = append(result, p)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// AffectedPackages returns packages along with every main module
+// package that imports any of them, directly or indirectly, and every
+// main module package whose tests import any of those.
+func (g *Graph) AffectedPackages(packages []string) []string {
+	seen := map[string]bool{}
+	queue := slices.Clone(packages)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		queue = append(queue, g.importedBy[p]...)
+	}
+	// A package whose tests import an affected package is affected too,
+	// but the packages importing it are not, since they do not import its
+	// tests.
+	result := make([]string, 0, len(seen))
+	for p := range seen {
+		result = append(result, p)
+		for _, t := range g.testedBy[p] {
+			if !seen[t] && !slices.Contains(result, t) {
+				result = append(result, t)
+			}
+		}
+	}
+	slices.Sort(result)
+	return result
+}
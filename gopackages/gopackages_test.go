@@ -0,0 +1,105 @@
+package gopackages_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/gopackages"
+	"github.com/stretchr/testify/assert"
+)
+
+const listOutput = `{
+	"ImportPath": "fmt",
+	"Dir": "/usr/local/go/src/fmt",
+	"Standard": true
+}
+{
+	"ImportPath": "example.com/app/internal/model",
+	"Dir": "/src/app/internal/model",
+	"Imports": ["fmt"],
+	"XTestImports": ["example.com/app/internal/model", "example.com/app/internal/testutil"],
+	"Module": {"Path": "example.com/app", "Main": true}
+}
+{
+	"ImportPath": "example.com/app/internal/store",
+	"Dir": "/src/app/internal/store",
+	"Imports": ["example.com/app/internal/model"],
+	"TestImports": ["example.com/app/internal/testutil"],
+	"Module": {"Path": "example.com/app", "Main": true}
+}
+{
+	"ImportPath": "example.com/app/internal/testutil",
+	"Dir": "/src/app/internal/testutil",
+	"Imports": ["example.com/app/internal/util"],
+	"Module": {"Path": "example.com/app", "Main": true}
+}
+{
+	"ImportPath": "example.com/app/internal/util",
+	"Dir": "/src/app/internal/util",
+	"Module": {"Path": "example.com/app", "Main": true}
+}
+{
+	"ImportPath": "example.com/app",
+	"Dir": "/src/app",
+	"Imports": ["example.com/app/internal/store", "fmt"],
+	"Module": {"Path": "example.com/app", "Main": true}
+}
+`
+
+func TestGraph(t *testing.T) {
+	g, err := gopackages.Parse(strings.NewReader(listOutput))
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"example.com/app",
+			"example.com/app/internal/model",
+			"example.com/app/internal/store",
+			"example.com/app/internal/testutil",
+			"example.com/app/internal/util",
+		},
+		g.Packages(),
+	)
+	changed := g.ChangedPackages([]string{
+		"/src/app/internal/model/user.go",
+		"/src/app/internal/model/user_test.go",
+		"/src/app/README.md",
+		"/elsewhere/file.go",
+	})
+	assert.Equal(t, []string{"example.com/app", "example.com/app/internal/model"}, changed)
+	assert.Equal(
+		t,
+		[]string{"example.com/app"},
+		g.AffectedPackages([]string{"example.com/app"}),
+	)
+	assert.Equal(
+		t,
+		[]string{
+			"example.com/app",
+			"example.com/app/internal/model",
+			"example.com/app/internal/store",
+		},
+		g.AffectedPackages([]string{"example.com/app/internal/model"}),
+	)
+	// Packages whose tests import an affected package are affected, but
+	// not the packages importing them.
+	assert.Equal(
+		t,
+		[]string{
+			"example.com/app/internal/model",
+			"example.com/app/internal/store",
+			"example.com/app/internal/testutil",
+		},
+		g.AffectedPackages([]string{"example.com/app/internal/testutil"}),
+	)
+	assert.Equal(
+		t,
+		[]string{
+			"example.com/app/internal/model",
+			"example.com/app/internal/store",
+			"example.com/app/internal/testutil",
+			"example.com/app/internal/util",
+		},
+		g.AffectedPackages([]string{"example.com/app/internal/util"}),
+	)
+}
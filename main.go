@@ -1,23 +1,66 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
-	"github.com/fsnotify/fsnotify"
-	"github.com/jakewan/go-procrotator/childproc"
+	"github.com/jakewan/go-procrotator/buildcache"
+	"github.com/jakewan/go-procrotator/command"
 	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/pipeline"
+	"github.com/jakewan/go-procrotator/reload"
 	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/scaffold"
 	"github.com/jakewan/go-procrotator/watchdirs"
 )
 
 func main() {
 	l := logger.NewLogger("go-procrotator", os.Stderr)
-	if cfg, err := runtimeconfig.Build(os.Args[1:]); err != nil {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "init":
+			if err := runInit(l, args[1:]); err != nil {
+				l.Errorf(logger.ERROR, err.Error())
+				os.Exit(1)
+			}
+			return
+		case "presets":
+			if err := printPresets(); err != nil {
+				l.Errorf(logger.ERROR, err.Error())
+				os.Exit(1)
+			}
+			return
+		case "config":
+			if err := runConfig(args[1:]); errors.Is(err, flag.ErrHelp) {
+				return
+			} else if err != nil {
+				l.Errorf(logger.ERROR, err.Error())
+				os.Exit(1)
+			}
+			return
+		case "run":
+			if a, err := runSubcommandArgs(args[1:]); err != nil {
+				l.Errorf(logger.ERROR, err.Error())
+				os.Exit(2)
+			} else {
+				args = a
+			}
+		}
+	}
+	if cfg, err := runtimeconfig.Build(args); errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
 		l.Errorf(logger.ERROR, err.Error())
 		os.Exit(1)
 	} else {
@@ -35,39 +78,42 @@ func main() {
 			l.Errorf(logger.WARNING, "Warning, no include file regexes detected.")
 			os.Exit(1)
 		} else {
-			startProcessing(wd, l, cfg)
+			startProcessing(wd, l, cfg, args)
 		}
 	}
 }
 
-func startProcessing(wd string, l logger.Logger, cfg runtimeconfig.Config) {
-	if watchDirs, err := getDirectoriesToWatch(wd); err != nil {
+func startProcessing(wd string, l logger.Logger, cfg runtimeconfig.Config, args []string) {
+	if watchDirs, err := watchedDirectories(wd, cfg); err != nil {
 		l.Errorf(logger.ERROR, err.Error())
 		os.Exit(1)
 	} else {
-		startFilesystemWatcher(l, cfg, watchDirs)
+		startFilesystemWatcher(l, cfg, args, wd, watchDirs)
 	}
 }
 
-func startFilesystemWatcher(l logger.Logger, cfg runtimeconfig.Config, watchDirs []string) {
-	if w, err := fsnotify.NewWatcher(); err != nil {
+func startFilesystemWatcher(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	args []string,
+	wd string,
+	watchDirs []string,
+) {
+	if w, err := newFileWatcher(l, cfg, watchDirs); err != nil {
 		l.Errorf(logger.ERROR, err.Error())
 		os.Exit(1)
 	} else {
-		for _, d := range watchDirs {
-			if err := w.Add(d); err != nil {
-				l.Errorf(logger.ERROR, err.Error())
-				os.Exit(1)
-			}
-		}
-		startBackgroundProcesses(l, cfg, w)
+		startBackgroundProcesses(l, cfg, args, wd, w, watchDirs)
 	}
 }
 
 func startBackgroundProcesses(
 	l logger.Logger,
 	cfg runtimeconfig.Config,
-	watcher *fsnotify.Watcher,
+	args []string,
+	wd string,
+	watcher watchdirs.Watcher,
+	watchDirs []string,
 ) {
 	defer watcher.Close()
 	sigChan := make(chan os.Signal, 1)
@@ -75,30 +121,38 @@ func startBackgroundProcesses(
 	trapSignalsDone := make(chan bool, 1)
 	watchDirEvents := make(chan watchdirs.WatcherEvent)
 	watchDirErrors := make(chan error)
-	fileChangedChan := make(chan watchdirs.FileChangedEvent)
 	quitWatchDirs := make(chan bool)
 	watchDirsDone := make(chan bool)
-
-	childProcManagerDone := make(chan bool)
-	go childproc.StartChildProcess(
-		newChildProcDeps(l),
-		cfg,
-		fileChangedChan,
-		childProcManagerDone,
-	)
-
-	eventProcessingDone := make(chan bool)
-	go watchdirs.StartEventProcessing(
-		newWatchDirsDeps(l),
-		cfg.IncludeFileRegexes(),
-		cfg.ExcludeFileRegexes(),
-		fileChangedChan,
+	p := pipeline.Start(
+		newPipelineDeps(l),
+		pipeline.Settings{
+			Config:  cfg,
+			Filters: watchFilters(wd, cfg),
+			Restart: restartFilters(wd, cfg),
+		},
 		watchDirEvents,
 		watchDirErrors,
-		eventProcessingDone,
 	)
 	l.Errorf(logger.DEBUG, "Waiting for file change events")
 
+	// Apply changes to the config file while running.
+	configWatcher := startConfigWatcher(l, cfg, args, wd)
+	configUpdatesDone := make(chan bool, 1)
+	if configWatcher != nil {
+		go applyConfigUpdates(
+			l,
+			cfg,
+			wd,
+			watcher,
+			watchDirs,
+			configWatcher.updates,
+			p,
+			configUpdatesDone,
+		)
+	} else {
+		configUpdatesDone <- true
+	}
+
 	go startWatchDirs(
 		l,
 		watcher,
@@ -113,59 +167,369 @@ func startBackgroundProcesses(
 	<-trapSignalsDone
 	l.Errorf(logger.DEBUG, "Quit signal received")
 
+	// Stop reloading the configuration and wait for any configuration
+	// being applied.
+	if configWatcher != nil {
+		configWatcher.stop()
+	}
+	<-configUpdatesDone
+	l.Errorf(logger.DEBUG, "Config file watching completed")
+
 	// Signal the director watching process to quit and wait for completion.
 	quitWatchDirs <- true
 	<-watchDirsDone
 	l.Errorf(logger.DEBUG, "Directory watching processes completed")
 
-	// Signal the event processor to quit by closing its receiving channels
-	// and wait for it to signal completion.
+	// Signal the pipeline to quit by closing the channels of the event
+	// processor and wait for its stages to complete.
 	close(watchDirEvents)
 	close(watchDirErrors)
-	<-eventProcessingDone
-	l.Errorf(logger.DEBUG, "Event processor completed")
+	p.Stop()
+}
+
+// watchFilters returns the filters determining which of the changes
+// under the working directory wd and the watch paths are reported, given
+// the configuration cfg. Files matching an on_change rule are observed
+// even when the main include regexes do not match them.
+func watchFilters(wd string, cfg runtimeconfig.Config) watchdirs.Filters {
+	result := restartFilters(wd, cfg)
+	result.IncludeFileRegexes = slices.Clone(result.IncludeFileRegexes)
+	for _, r := range cfg.OnChangeRules() {
+		result.IncludeFileRegexes = append(result.IncludeFileRegexes, r.IncludeFileRegexes...)
+	}
+	return result
+}
+
+// restartFilters returns the filters determining which of the changes
+// under the working directory wd and the watch paths restart the server
+// process when no on_change rule matches them, given the configuration
+// cfg.
+func restartFilters(wd string, cfg runtimeconfig.Config) watchdirs.Filters {
+	// Watch paths that cannot be read are reported when walking the
+	// watched directories.
+	roots, files, _ := splitWatchPaths(wd, cfg)
+
+	// Writing the build cache must not trigger a restart, and changes to
+	// the config files are applied by reloading them.
+	excludeFileRegexes := append(
+		slices.Clone(cfg.ExcludeFileRegexes()),
+		*regexp.MustCompile(regexp.QuoteMeta(filepath.Join(wd, buildcache.FileName)) + "$"),
+	)
+	for _, p := range configFiles(wd, cfg) {
+		excludeFileRegexes = append(
+			excludeFileRegexes,
+			*regexp.MustCompile("^" + regexp.QuoteMeta(p) + "$"),
+		)
+	}
+
+	return watchdirs.Filters{
+		IncludeFileRegexes: cfg.IncludeFileRegexes(),
+		ExcludeFileRegexes: excludeFileRegexes,
+		AlwaysIncludePaths: append(absolutePaths(wd, cfg.EnvFiles()), files...),
+		Roots:              append([]string{wd}, roots...),
+	}
+}
+
+// configWatcher reloads the config file when it changes.
+type configWatcher struct {
+	updates <-chan runtimeconfig.Config
+	stop    func()
+}
+
+// configFiles returns the absolute paths of the config file of cfg and
+// the files it extends.
+func configFiles(wd string, cfg runtimeconfig.Config) []string {
+	if cfg.ConfigFile() == "" {
+		return nil
+	}
+	return append(absolutePaths(wd, []string{cfg.ConfigFile()}), cfg.BaseConfigFiles()...)
+}
+
+// startConfigWatcher watches the config files of cfg and sends the
+// rebuilt configuration on the updates channel of the result after each
+// change. The configuration is rebuilt from the command line arguments
+// args, with the working directory wd and the same config file. The
+// files extended by the config file are watched as of startup. It
+// returns nil when cfg has no config file or it cannot be watched.
+func startConfigWatcher(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	args []string,
+	wd string,
+) *configWatcher {
+	paths := configFiles(wd, cfg)
+	if len(paths) == 0 {
+		return nil
+	}
+	path := paths[0]
+	// Watch the directories since editors often replace a file on save.
+	var dirs []string
+	for _, p := range paths {
+		if !slices.Contains(dirs, filepath.Dir(p)) {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+	}
+	w, err := newFileWatcher(l, cfg, dirs)
+	if err != nil {
+		l.Errorf(logger.WARNING, "Changes to %s will not be applied: %s", path, err)
+		return nil
+	}
+	buildArgs := append(slices.Clone(args), "-d", wd, "-config", path)
+	events := make(chan watchdirs.WatcherEvent)
+	updates := make(chan runtimeconfig.Config)
+	quit := make(chan bool)
+	watchDone := make(chan bool)
+	reloaderDone := make(chan bool)
+	go startWatchDirs(l, w, events, quit, watchDone)
+	go reload.StartReloader(
+		newReloadDeps(l),
+		paths,
+		func() (runtimeconfig.Config, error) {
+			return runtimeconfig.Build(buildArgs)
+		},
+		events,
+		updates,
+		reloaderDone,
+	)
+	return &configWatcher{
+		updates: updates,
+		stop: func() {
+			quit <- true
+			<-watchDone
+			close(events)
+			<-reloaderDone
+			close(updates)
+			w.Close()
+		},
+	}
+}
+
+// applyConfigUpdates applies each configuration received from updates,
+// replacing cfg. The filters of the event processor of the pipeline p
+// and the directories registered with watcher are updated as needed, and
+// the on_change dispatcher and the child process manager of p receive
+// the new configuration. A change of mode cannot be applied and is rejected.
+func applyConfigUpdates(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	wd string,
+	watcher watchdirs.Watcher,
+	watchDirs []string,
+	updates <-chan runtimeconfig.Config,
+	p *pipeline.Pipeline,
+	done chan<- bool,
+) {
+	defer func() {
+		done <- true
+	}()
+	for next := range updates {
+		changes := runtimeconfig.Compare(cfg, next)
+		if changes.Mode {
+			l.Errorf(logger.ERROR, "Keeping the current configuration: changing the mode requires restarting go-procrotator")
+			continue
+		}
+		if changes.Watcher {
+			l.Errorf(logger.ERROR, "Keeping the current configuration: changing the watcher requires restarting go-procrotator")
+			continue
+		}
+		if changes.IgnoreDirectories || changes.WatchPaths {
+			if dirs, err := watchedDirectories(wd, next); err != nil {
+				l.Errorf(logger.ERROR, "Keeping the current configuration: %s", err)
+				continue
+			} else {
+				watchDirs = updateWatchedDirectories(l, next, watcher, watchDirs, dirs)
+			}
+		}
+		if changes.LogLevel {
+			l.SetErrorLevel(next.LogLevel())
+		}
+		if changes.WatchFilters || changes.WatchPaths {
+			p.UpdateFilters(watchFilters(wd, next))
+		}
+		p.UpdateConfig(next, restartFilters(wd, next))
+		cfg = next
+		l.Errorf(logger.DEBUG, "%s", cfg)
+	}
+}
+
+// updateWatchedDirectories registers the directories of next that are
+// not in current with w and unregisters those no longer in next,
+// returning next. Reaching the watch limit is reported along with the
+// ways of avoiding it for the configuration cfg.
+func updateWatchedDirectories(l logger.Logger, cfg runtimeconfig.Config, w watchdirs.Watcher, current, next []string) []string {
+	limited := 0
+	for _, d := range next {
+		if !slices.Contains(current, d) {
+			if err := w.Add(d); watchdirs.IsWatchLimitError(err) {
+				limited++
+			} else if err != nil {
+				l.Errorf(logger.ERROR, "Error watching %s: %s", d, err)
+			}
+		}
+	}
+	if limited > 0 {
+		l.Errorf(logger.ERROR, "Not watching %d directories.\n%s", limited, watchLimitReport(cfg, next))
+	}
+	for _, d := range current {
+		if !slices.Contains(next, d) {
+			if err := w.Remove(d); err != nil {
+				l.Errorf(logger.DEBUG, "Error unwatching %s: %s", d, err)
+			}
+		}
+	}
+	return next
+}
+
+// runInit writes a config file for the project in the chosen directory.
+func runInit(l logger.Logger, args []string) error {
+	f := flag.NewFlagSet("go-procrotator init", flag.ExitOnError)
+	dir := f.String("d", ".", "The directory of the project")
+	force := f.Bool("force", false, "Overwrite an existing config file")
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+	path, p, err := scaffold.Write(*dir, *force)
+	if err != nil {
+		return err
+	}
+	l.Errorf(logger.NOTICE, "Wrote %s", path)
+	if p.Preset == runtimeconfig.NoPreset {
+		l.Errorf(logger.WARNING, "No project type detected. Set include_file_regexes in %s.", path)
+	}
+	if p.ServerCommand == "" {
+		l.Errorf(logger.WARNING, "No server command detected. Set server_command in %s.", path)
+	}
+	return nil
+}
+
+// printPresets lists the settings supplied by each preset.
+func printPresets() error {
+	for i, p := range runtimeconfig.AllPresets() {
+		description, err := p.Describe()
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("# preset = %q\n%s", p.String(), description)
+	}
+	return nil
+}
+
+// runConfig runs the config subcommand, which validates or prints the
+// configuration resolved from the config file and the remaining flags.
+func runConfig(args []string) error {
+	const usage = "usage: go-procrotator config validate|show [-format toml|json] [flags]"
+	if len(args) < 1 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "validate":
+		var ce *runtimeconfig.ConfigError
+		if _, err := runtimeconfig.Build(args[1:]); errors.As(err, &ce) {
+			for _, p := range ce.Problems {
+				fmt.Fprintln(os.Stderr, p)
+			}
+			return fmt.Errorf("found %d configuration problem(s)", len(ce.Problems))
+		} else if err != nil {
+			return err
+		}
+		fmt.Println("Configuration is valid")
+		return nil
+	case "show":
+		format, buildArgs := splitFormatFlag(args[1:])
+		cfg, err := runtimeconfig.Build(buildArgs)
+		if err != nil {
+			return err
+		}
+		switch format {
+		case "", "toml":
+			if s, err := runtimeconfig.FormatTOML(cfg); err != nil {
+				return err
+			} else {
+				fmt.Print(s)
+			}
+		case "json":
+			if b, err := runtimeconfig.FormatJSON(cfg); err != nil {
+				return err
+			} else {
+				fmt.Println(string(b))
+			}
+		default:
+			return fmt.Errorf("format value not supported: %s", format)
+		}
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+// splitFormatFlag removes the -format flag of the config show subcommand
+// from args, returning its value and the arguments left for
+// runtimeconfig.Build.
+func splitFormatFlag(args []string) (string, []string) {
+	var (
+		format string
+		rest   []string
+	)
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || name != "format" {
+			rest = append(rest, args[i])
+		} else if hasValue {
+			format = value
+		} else if i+1 < len(args) {
+			format = args[i+1]
+			i++
+		}
+	}
+	return format, rest
+}
 
-	// Signal the child process manager to quit by closing the file change
-	// channel and wait for it to signal completion.
-	close(fileChangedChan)
-	<-childProcManagerDone
-	l.Errorf(logger.DEBUG, "Child process manager completed")
+// runSubcommandArgs converts the arguments of the run subcommand,
+// "[flags] -- command [args...]", to the equivalent exec mode flags. The
+// command arguments are quoted so that the exec command splits into the
+// same arguments.
+func runSubcommandArgs(args []string) ([]string, error) {
+	if i := slices.Index(args, "--"); i < 0 || i == len(args)-1 {
+		return nil, errors.New("usage: go-procrotator run [flags] -- command [args...]")
+	} else {
+		quoted := make([]string, 0, len(args)-i-1)
+		for _, a := range args[i+1:] {
+			quoted = append(quoted, command.Quote(a))
+		}
+		return append(
+			slices.Clone(args[:i]),
+			"-mode", runtimeconfig.ExecMode.String(),
+			"-execcommand", strings.Join(quoted, " "),
+		), nil
+	}
 }
 
-func startWatchDirs(l logger.Logger, w *fsnotify.Watcher, changes chan<- watchdirs.WatcherEvent, quit <-chan bool, done chan<- bool) {
+// startWatchDirs forwards the events of w to changes until quit
+// receives.
+func startWatchDirs(l logger.Logger, w watchdirs.Watcher, changes chan<- watchdirs.WatcherEvent, quit <-chan bool, done chan<- bool) {
 	defer func() {
 		done <- true
 	}()
+	events := w.Events()
+	errs := w.Errors()
 	for {
 		select {
 		case <-quit:
 			return
-		case ev, ok := <-w.Events:
+		case ev, ok := <-events:
 			if ok {
-				var ops []watchdirs.WatcherEventOp
-				if ev.Op.Has(fsnotify.Chmod) {
-					ops = append(ops, watchdirs.CHMOD)
-				}
-				if ev.Op.Has(fsnotify.Create) {
-					ops = append(ops, watchdirs.CREATE)
-				}
-				if ev.Op.Has(fsnotify.Remove) {
-					ops = append(ops, watchdirs.REMOVE)
-				}
-				if ev.Op.Has(fsnotify.Rename) {
-					ops = append(ops, watchdirs.RENAME)
-				}
-				if ev.Op.Has(fsnotify.Write) {
-					ops = append(ops, watchdirs.WRITE)
-				}
-				changes <- watchdirs.WatcherEvent{
-					Path: ev.Name,
-					Ops:  ops,
-				}
+				changes <- ev
+			} else {
+				events = nil
 			}
-		case err, ok := <-w.Errors:
+		case err, ok := <-errs:
 			if ok {
-				l.Errorf(logger.ERROR, "Error from fsnotify: %s", err)
+				l.Errorf(logger.ERROR, "Error from the file watcher: %s", err)
+			} else {
+				errs = nil
 			}
 		}
 	}
@@ -178,20 +542,75 @@ func startTrapSignals(sigChan <-chan os.Signal, done chan<- bool) {
 	<-sigChan
 }
 
-type watchdirsDeps struct {
+type pipelineDeps struct {
 	logger logger.Logger
 }
 
-// Logger implements watchdirs.Dependencies.
-func (w *watchdirsDeps) Logger() logger.Logger {
-	return w.logger
+// Logger implements pipeline.Dependencies.
+func (p *pipelineDeps) Logger() logger.Logger {
+	return p.logger
+}
+
+// Now implements pipeline.Dependencies.
+func (p *pipelineDeps) Now() time.Time {
+	return time.Now()
+}
+
+func newPipelineDeps(l logger.Logger) pipeline.Dependencies {
+	return &pipelineDeps{logger: l}
+}
+
+// watchedDirectories returns the directories to watch for the
+// configuration cfg: those of the trees of the working directory wd and
+// of the directories among the watch paths, and the directories holding
+// the files among the watch paths.
+func watchedDirectories(wd string, cfg runtimeconfig.Config) ([]string, error) {
+	roots, files, err := splitWatchPaths(wd, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, root := range append([]string{wd}, roots...) {
+		if d, err := getDirectoriesToWatch(root, cfg.IgnoreDirectories()); err != nil {
+			return nil, err
+		} else {
+			dirs = append(dirs, d...)
+		}
+	}
+	for _, f := range files {
+		dirs = append(dirs, filepath.Dir(f))
+	}
+	// Roots may be nested within each other.
+	seen := make(map[string]bool, len(dirs))
+	result := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if !seen[d] {
+			seen[d] = true
+			result = append(result, d)
+		}
+	}
+	return result, nil
 }
 
-func newWatchDirsDeps(l logger.Logger) watchdirs.Dependencies {
-	return &watchdirsDeps{logger: l}
+// splitWatchPaths resolves the watch paths of cfg against the working
+// directory wd, returning the directories and the files among them.
+func splitWatchPaths(wd string, cfg runtimeconfig.Config) ([]string, []string, error) {
+	var dirs, files []string
+	for _, p := range absolutePaths(wd, cfg.WatchPaths()) {
+		if info, err := os.Stat(p); err != nil {
+			return nil, nil, fmt.Errorf("reading watch path: %w", err)
+		} else if info.IsDir() {
+			dirs = append(dirs, p)
+		} else {
+			files = append(files, p)
+		}
+	}
+	return dirs, files, nil
 }
 
-func getDirectoriesToWatch(root string) ([]string, error) {
+// getDirectoriesToWatch returns root and its subdirectories, skipping
+// the directories whose name is one of ignoreDirectories.
+func getDirectoriesToWatch(root string, ignoreDirectories []string) ([]string, error) {
 	result := []string{}
 	if err := filepath.WalkDir(
 		root,
@@ -200,6 +619,9 @@ func getDirectoriesToWatch(root string) ([]string, error) {
 				return err
 			}
 			if d.IsDir() {
+				if path != root && slices.Contains(ignoreDirectories, d.Name()) {
+					return filepath.SkipDir
+				}
 				result = append(result, path)
 			}
 			return nil
@@ -210,15 +632,28 @@ func getDirectoriesToWatch(root string) ([]string, error) {
 	return result, nil
 }
 
-type childprocmanagerDeps struct {
+// absolutePaths resolves each of paths against the directory wd.
+func absolutePaths(wd string, paths []string) []string {
+	result := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			result = append(result, filepath.Clean(p))
+		} else {
+			result = append(result, filepath.Join(wd, p))
+		}
+	}
+	return result
+}
+
+type reloadDeps struct {
 	logger logger.Logger
 }
 
-// Logger implements childprocmanager.Dependencies.
-func (c *childprocmanagerDeps) Logger() logger.Logger {
-	return c.logger
+// Logger implements reload.Dependencies.
+func (r *reloadDeps) Logger() logger.Logger {
+	return r.logger
 }
 
-func newChildProcDeps(l logger.Logger) childproc.Dependencies {
-	return &childprocmanagerDeps{logger: l}
+func newReloadDeps(l logger.Logger) reload.Dependencies {
+	return &reloadDeps{logger: l}
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSubcommandArgs(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		args          []string
+		expectedFlags []string
+		expectedArgs  []string
+		expectedError string
+	}{
+		{
+			desc:          "plain arguments",
+			args:          []string{"-i", `\.go$`, "--", "go", "test", "./..."},
+			expectedFlags: []string{"-i", `\.go$`, "-mode", "exec"},
+			expectedArgs:  []string{"go", "test", "./..."},
+		},
+		{
+			desc:          "arguments needing quotes",
+			args:          []string{"--", "sh", "-c", `echo "it's" $HOME`, ""},
+			expectedFlags: []string{"-mode", "exec"},
+			expectedArgs:  []string{"sh", "-c", `echo "it's" $HOME`, ""},
+		},
+		{
+			desc:          "missing separator",
+			args:          []string{"go", "test"},
+			expectedError: "usage: go-procrotator run [flags] -- command [args...]",
+		},
+		{
+			desc:          "missing command",
+			args:          []string{"-i", `\.go$`, "--"},
+			expectedError: "usage: go-procrotator run [flags] -- command [args...]",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			result, err := runSubcommandArgs(tc.args)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			if !assert.NoError(t, err) || !assert.Len(t, result, len(tc.expectedFlags)+2) {
+				return
+			}
+			assert.Equal(t, tc.expectedFlags, result[:len(tc.expectedFlags)])
+			assert.Equal(t, "-execcommand", result[len(tc.expectedFlags)])
+			name, args, err := command.Split(result[len(result)-1], nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedArgs, append([]string{name}, args...))
+		})
+	}
+}
+
+func TestAddLimitedDirs(t *testing.T) {
+	wd := t.TempDir()
+	cfg, err := runtimeconfig.Build([]string{"-d", wd, "-i", `\.go$`, "-s", "./app"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	dirs := []string{wd, filepath.Join(wd, "cmd"), filepath.Join(wd, "third_party")}
+	for i := range 9 {
+		dirs = append(dirs, filepath.Join(wd, "third_party", fmt.Sprintf("lib%d", i)))
+	}
+
+	// Below the limit, every directory is watched.
+	w := watchdirs.NewFakeWatcher()
+	w.SetWatchLimit(len(dirs))
+	result, err := addLimitedDirs(cfg, w, dirs)
+	assert.NoError(t, err)
+	assert.Equal(t, w, result)
+
+	// Reaching the limit reports the largest subtrees, and suggests
+	// ignoring those holding a tenth of the directories or more.
+	w = watchdirs.NewFakeWatcher()
+	w.SetWatchLimit(5)
+	_, err = addLimitedDirs(cfg, w, dirs)
+	if !assert.True(t, watchdirs.IsWatchLimitError(err), "%v", err) {
+		return
+	}
+	lines := strings.Split(err.Error(), "\n")
+	assert.Contains(t, lines[1], "Reached the inotify watch limit: each of the 12 watched directories needs a watch")
+	assert.Equal(t, []string{
+		"The largest subtrees, with their number of directories, are:",
+		"  " + filepath.Join(wd, "third_party") + ": 10",
+		"  " + filepath.Join(wd, "cmd") + ": 1",
+		`Skip those that need not be watched with: ignore_directories = ["third_party"]`,
+		`Or set watcher = "fanotify" or poll_over_watch_limit = true to watch them without inotify watches.`,
+	}, lines[2:7])
+}
+
+func TestWatchedDirectories(t *testing.T) {
+	base := t.TempDir()
+	for _, d := range []string{
+		"app/cmd",
+		"app/node_modules/lib",
+		"shared/api",
+		"config/templates",
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(base, d), 0o755))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(base, "config", "app.yaml"), nil, 0o644))
+	wd := filepath.Join(base, "app")
+	testCases := []struct {
+		desc          string
+		watchPaths    string
+		expectedDirs  []string
+		expectedRoots []string
+		expectedFiles []string
+		expectedError string
+	}{
+		{
+			desc:         "working directory",
+			watchPaths:   `[]`,
+			expectedDirs: []string{"app", "app/cmd"},
+		},
+		{
+			// Only the directory holding a file is watched, not its
+			// subdirectories.
+			desc:          "directory and file roots",
+			watchPaths:    `["../shared", "../config/app.yaml"]`,
+			expectedDirs:  []string{"app", "app/cmd", "shared", "shared/api", "config"},
+			expectedRoots: []string{"shared"},
+			expectedFiles: []string{"config/app.yaml"},
+		},
+		{
+			desc:          "nested roots",
+			watchPaths:    `["cmd", "."]`,
+			expectedDirs:  []string{"app", "app/cmd"},
+			expectedRoots: []string{"app/cmd", "app"},
+		},
+		{
+			desc:          "missing path",
+			watchPaths:    `["../shared", "../missing"]`,
+			expectedError: "reading watch path: stat " + filepath.Join(base, "missing") + ": no such file or directory",
+		},
+	}
+	// paths resolves the paths relative to base.
+	paths := func(rel []string) []string {
+		var result []string
+		for _, p := range rel {
+			result = append(result, filepath.Join(base, p))
+		}
+		return result
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			configFile := filepath.Join(wd, "procrotator.toml")
+			content := `server_command = "./app"
+ignore_directories = ["node_modules"]
+watch_paths = ` + tc.watchPaths + "\n"
+			assert.NoError(t, os.WriteFile(configFile, []byte(content), 0o644))
+			cfg, err := runtimeconfig.Build([]string{"-config", configFile})
+			if !assert.NoError(t, err) {
+				return
+			}
+			roots, files, splitErr := splitWatchPaths(wd, cfg)
+			dirs, err := watchedDirectories(wd, cfg)
+			if tc.expectedError != "" {
+				assert.EqualError(t, splitErr, tc.expectedError)
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, splitErr)
+			assert.NoError(t, err)
+			assert.Equal(t, paths(tc.expectedRoots), roots)
+			assert.Equal(t, paths(tc.expectedFiles), files)
+			assert.Equal(t, paths(tc.expectedDirs), dirs)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package onchange dispatches file change events to the commands of the
+// configured on_change rules before they reach the child process
+// manager.
+package onchange
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jakewan/go-procrotator/command"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+type Dependencies interface {
+	Logger() logger.Logger
+}
+
+// quietPeriod is how long the dispatcher waits for further changes
+// before running the commands of the matched rules, so that a burst of
+// changes runs each command once.
+const quietPeriod = 200 * time.Millisecond
+
+// Settings configure the dispatcher. Config provides the on_change rules
+// and the environment of their commands. Changes matching no rule are
+// forwarded when they pass the Restart filters, which leave out the
+// include regexes of the rules.
+type Settings struct {
+	Config  runtimeconfig.Config
+	Restart watchdirs.Filters
+}
+
+// StartDispatcher receives file change events from in and runs the
+// commands of the rules that match them. Events are forwarded to out
+// when a matching rule declares restart = true, or when no rule matches
+// them and they pass the restart filters. Settings received from
+// updates replace settings, after the pending commands have run when the
+// rules changed.
+//
+// Commands run in the background, one batch after another, so that
+// events keep flowing while they run. The dispatcher runs until in is
+// closed and the commands have completed.
+func StartDispatcher(
+	deps Dependencies,
+	settings Settings,
+	updates <-chan Settings,
+	in <-chan watchdirs.FileChangedEvent,
+	out chan<- watchdirs.FileChangedEvent,
+	done chan<- bool,
+) {
+	defer func() {
+		done <- true
+	}()
+	l := deps.Logger()
+	rules := settings.Config.OnChangeRules()
+	pending := make([][]string, len(rules))
+	var (
+		quiet <-chan time.Time
+		// running is closed when the last batch of commands completes.
+		running = make(chan struct{})
+	)
+	close(running)
+	runPending := func() {
+		running = startCommands(l, settings.Config, rules, pending, running)
+		pending = make([][]string, len(rules))
+	}
+	for {
+		select {
+		case ev, ok := <-in:
+			if !ok {
+				runPending()
+				<-running
+				return
+			}
+			matched := false
+			restart := false
+			for i, r := range rules {
+				if r.Matches(ev.Path, settings.Config.Roots()) {
+					matched = true
+					restart = restart || r.Restart
+					pending[i] = append(pending[i], ev.Path)
+				}
+			}
+			if matched {
+				quiet = time.After(quietPeriod)
+			}
+			if restart || (!matched && settings.Restart.Matches(ev.Path)) {
+				out <- ev
+			} else {
+				l.Errorf(logger.DEBUG, "Not restarting for %s", ev.Path)
+			}
+		case <-quiet:
+			quiet = nil
+			runPending()
+		case next := <-updates:
+			if runtimeconfig.Compare(settings.Config, next.Config).OnChangeRules {
+				runPending()
+				quiet = nil
+				rules = next.Config.OnChangeRules()
+				pending = make([][]string, len(rules))
+			}
+			settings = next
+		}
+	}
+}
+
+// startCommands runs the commands of the rules with pending changes in
+// the background once the previous batch, signalled by closing prev,
+// has completed. The returned channel is closed when they complete.
+func startCommands(
+	l logger.Logger,
+	cfg runtimeconfig.Config,
+	rules []runtimeconfig.OnChangeRule,
+	pending [][]string,
+	prev <-chan struct{},
+) chan struct{} {
+	result := make(chan struct{})
+	go func() {
+		defer close(result)
+		<-prev
+		for i, paths := range pending {
+			if len(paths) < 1 {
+				continue
+			}
+			l.Errorf(logger.INFO, "Running on_change command: %s", rules[i].Command)
+			startedAt := time.Now()
+			if err := runCommand(l, cfg, rules[i].Command, paths); err != nil {
+				l.Errorf(logger.ERROR, "Error running on_change command: %s", err)
+			} else {
+				l.Errorf(logger.DEBUG, "on_change command completed in %s", time.Since(startedAt))
+			}
+		}
+	}()
+	return result
+}
+
+// runCommand runs the on_change command c for changedFiles. Like
+// preamble commands, it is expanded as a template and runs with the
+// environment of a cycle.
+func runCommand(l logger.Logger, cfg runtimeconfig.Config, c string, changedFiles []string) error {
+	cyc, err := command.NewCycle(l, cfg, 0, changedFiles)
+	if err != nil {
+		return err
+	}
+	defer cyc.Close()
+	// on_change commands run independently of the restarts.
+	delete(cyc.Env, command.EnvRestartCount)
+	proc, err := cyc.Command(context.Background(), c)
+	if err != nil {
+		return err
+	}
+	if err := proc.Run(); err != nil {
+		return fmt.Errorf("running %s: %w", c, err)
+	}
+	return nil
+}
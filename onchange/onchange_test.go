@@ -0,0 +1,162 @@
+package onchange_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/onchange"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+type testDeps struct{}
+
+// Logger implements onchange.Dependencies.
+func (testDeps) Logger() logger.Logger {
+	return logger.NewLogger("test", io.Discard)
+}
+
+// buildSettings writes content as the config file of a temporary
+// directory, replacing {{dir}} with the directory, and returns the
+// settings of the dispatcher for it along with the directory.
+func buildSettings(t *testing.T, content string) (onchange.Settings, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "procrotator.toml")
+	content = strings.ReplaceAll(content, "{{dir}}", dir)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	cfg, err := runtimeconfig.Build([]string{"-config", path})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return onchange.Settings{
+		Config: cfg,
+		Restart: watchdirs.Filters{
+			IncludeFileRegexes: cfg.IncludeFileRegexes(),
+			ExcludeFileRegexes: cfg.ExcludeFileRegexes(),
+		},
+	}, dir
+}
+
+func TestStartDispatcher(t *testing.T) {
+	settings, dir := buildSettings(t, `include_file_regexes = ["\\.go$"]
+server_command = "./server"
+
+[env]
+GREETING = "hello"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "sh -c 'echo \"$GREETING $PROCROTATOR_CHANGED_FILES\" >> {{dir}}/css.log'"
+restart = false
+
+[[on_change]]
+include_file_regexes = ["^migrations/.*\\.sql$"]
+command = "true"
+
+[[on_change]]
+include_file_regexes = ["\\.txt$"]
+exclude_file_regexes = ["ignored"]
+command = "true"
+`)
+	in := make(chan watchdirs.FileChangedEvent)
+	out := make(chan watchdirs.FileChangedEvent, 10)
+	done := make(chan bool, 1)
+	go onchange.StartDispatcher(testDeps{}, settings, nil, in, out, done)
+	cssFile := filepath.Join(dir, "static", "site.css")
+	sqlFile := filepath.Join(dir, "migrations", "001.sql")
+	mainFile := filepath.Join(dir, "main.go")
+	in <- watchdirs.FileChangedEvent{Path: cssFile}
+	// The regexes of a rule also match paths relative to the working
+	// directory.
+	in <- watchdirs.FileChangedEvent{Path: sqlFile}
+	in <- watchdirs.FileChangedEvent{Path: mainFile}
+	// Matching the include regexes of a rule but also its exclude regexes
+	// does not restart the server.
+	in <- watchdirs.FileChangedEvent{Path: filepath.Join(dir, "ignored.txt")}
+	close(in)
+	<-done
+	close(out)
+	var forwarded []string
+	for ev := range out {
+		forwarded = append(forwarded, ev.Path)
+	}
+	assert.Equal(t, []string{sqlFile, mainFile}, forwarded)
+	b, err := os.ReadFile(filepath.Join(dir, "css.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello "+cssFile+"\n", string(b))
+}
+
+func TestStartDispatcherTemplates(t *testing.T) {
+	settings, dir := buildSettings(t, `include_file_regexes = ["\\.go$"]
+server_command = "./server"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "sh -c 'echo {{.ChangedFiles}} > {{dir}}/files.log; cat \"$PROCROTATOR_CHANGED_FILES_FILE\" > {{dir}}/list.log'"
+restart = false
+`)
+	in := make(chan watchdirs.FileChangedEvent)
+	done := make(chan bool, 1)
+	go onchange.StartDispatcher(testDeps{}, settings, nil, in, nil, done)
+	siteFile := filepath.Join(dir, "site.css")
+	themeFile := filepath.Join(dir, "theme.css")
+	in <- watchdirs.FileChangedEvent{Path: siteFile}
+	in <- watchdirs.FileChangedEvent{Path: themeFile}
+	close(in)
+	<-done
+
+	// The command is expanded like preamble commands, and the list of the
+	// changed files is available.
+	b, err := os.ReadFile(filepath.Join(dir, "files.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, siteFile+" "+themeFile+"\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "list.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, siteFile+"\n"+themeFile+"\n", string(b))
+}
+
+func TestStartDispatcherBackground(t *testing.T) {
+	settings, dir := buildSettings(t, `include_file_regexes = ["\\.go$"]
+server_command = "./server"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "sh -c 'touch {{dir}}/started; while [ ! -f {{dir}}/release ]; do sleep 0.01; done'"
+restart = false
+`)
+	in := make(chan watchdirs.FileChangedEvent)
+	out := make(chan watchdirs.FileChangedEvent)
+	done := make(chan bool, 1)
+	go onchange.StartDispatcher(testDeps{}, settings, nil, in, out, done)
+	in <- watchdirs.FileChangedEvent{Path: filepath.Join(dir, "site.css")}
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "started"))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Changes are forwarded while the command runs.
+	mainFile := filepath.Join(dir, "main.go")
+	in <- watchdirs.FileChangedEvent{Path: mainFile}
+	select {
+	case ev := <-out:
+		assert.Equal(t, mainFile, ev.Path)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "change was not forwarded while the command was running")
+	}
+
+	// The dispatcher completes once the command does.
+	close(in)
+	select {
+	case <-done:
+		assert.Fail(t, "dispatcher completed while the command was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "release"), nil, 0o644))
+	<-done
+}
@@ -0,0 +1,135 @@
+// Package pipeline connects the stages handling file changes: the event
+// processor filtering watcher events, the on_change dispatcher, and the
+// child process manager or the exec runner, depending on the mode.
+package pipeline
+
+import (
+	"time"
+
+	"github.com/jakewan/go-procrotator/childproc"
+	"github.com/jakewan/go-procrotator/execrunner"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/onchange"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+type Dependencies interface {
+	Logger() logger.Logger
+	// Now returns the current time, against which the minimum restart
+	// interval of the child process manager is measured.
+	Now() time.Time
+}
+
+// Settings configure the stages of a pipeline.
+type Settings struct {
+	Config runtimeconfig.Config
+	// Filters determine which watcher events are reported.
+	Filters watchdirs.Filters
+	// Restart determine which reported changes restart the server
+	// process when no on_change rule matches them.
+	Restart watchdirs.Filters
+}
+
+// Pipeline is a running set of stages.
+type Pipeline struct {
+	logger              logger.Logger
+	filterUpdates       chan watchdirs.Filters
+	dispatcherUpdates   chan onchange.Settings
+	configUpdates       chan runtimeconfig.Config
+	dispatchChan        chan watchdirs.FileChangedEvent
+	fileChangedChan     chan watchdirs.FileChangedEvent
+	eventProcessingDone chan bool
+	dispatcherDone      chan bool
+	childProcDone       chan bool
+}
+
+// Start starts the stages for the settings s, processing the events and
+// errors of a watcher.
+func Start(
+	deps Dependencies,
+	s Settings,
+	events <-chan watchdirs.WatcherEvent,
+	errors <-chan error,
+) *Pipeline {
+	p := &Pipeline{
+		logger:              deps.Logger(),
+		filterUpdates:       make(chan watchdirs.Filters),
+		dispatcherUpdates:   make(chan onchange.Settings),
+		configUpdates:       make(chan runtimeconfig.Config),
+		dispatchChan:        make(chan watchdirs.FileChangedEvent),
+		fileChangedChan:     make(chan watchdirs.FileChangedEvent),
+		eventProcessingDone: make(chan bool),
+		dispatcherDone:      make(chan bool),
+		childProcDone:       make(chan bool),
+	}
+	switch s.Config.Mode() {
+	case runtimeconfig.ExecMode:
+		go execrunner.StartExecRunner(
+			deps,
+			s.Config,
+			p.configUpdates,
+			p.fileChangedChan,
+			p.childProcDone,
+		)
+	default:
+		go childproc.StartChildProcess(
+			deps,
+			s.Config,
+			p.configUpdates,
+			p.fileChangedChan,
+			p.childProcDone,
+		)
+	}
+	go onchange.StartDispatcher(
+		deps,
+		onchange.Settings{Config: s.Config, Restart: s.Restart},
+		p.dispatcherUpdates,
+		p.dispatchChan,
+		p.fileChangedChan,
+		p.dispatcherDone,
+	)
+	go watchdirs.StartEventProcessing(
+		deps,
+		s.Filters,
+		p.filterUpdates,
+		p.dispatchChan,
+		events,
+		errors,
+		p.eventProcessingDone,
+	)
+	return p
+}
+
+// UpdateFilters replaces the filters of the event processor with f.
+func (p *Pipeline) UpdateFilters(f watchdirs.Filters) {
+	p.filterUpdates <- f
+}
+
+// UpdateConfig applies cfg to the on_change dispatcher, along with the
+// restart filters restart, and to the child process manager or the exec
+// runner.
+func (p *Pipeline) UpdateConfig(cfg runtimeconfig.Config, restart watchdirs.Filters) {
+	p.dispatcherUpdates <- onchange.Settings{Config: cfg, Restart: restart}
+	p.configUpdates <- cfg
+}
+
+// Stop waits for each stage to complete in turn, once the events and
+// errors channels passed to Start have been closed. The server process
+// is stopped last, after the pending on_change commands have run.
+func (p *Pipeline) Stop() {
+	<-p.eventProcessingDone
+	p.logger.Errorf(logger.DEBUG, "Event processor completed")
+
+	// Signal the on_change dispatcher to quit by closing its receiving
+	// channel and wait for it to signal completion.
+	close(p.dispatchChan)
+	<-p.dispatcherDone
+	p.logger.Errorf(logger.DEBUG, "On change dispatcher completed")
+
+	// Signal the child process manager to quit by closing the file change
+	// channel and wait for it to signal completion.
+	close(p.fileChangedChan)
+	<-p.childProcDone
+	p.logger.Errorf(logger.DEBUG, "Child process manager completed")
+}
@@ -0,0 +1,360 @@
+package pipeline_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/pipeline"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDeps provides a clock that only moves when advanced, so that the
+// minimum restart interval does not depend on how fast the test runs,
+// and keeps the log for the test to wait on.
+type testDeps struct {
+	mu     sync.Mutex
+	now    time.Time
+	log    strings.Builder
+	logger logger.Logger
+}
+
+func newTestDeps() *testDeps {
+	d := &testDeps{now: time.Unix(0, 0)}
+	d.logger = logger.NewLogger("test", d)
+	return d
+}
+
+// Logger implements pipeline.Dependencies.
+func (d *testDeps) Logger() logger.Logger {
+	return d.logger
+}
+
+// Write implements io.Writer for the logger.
+func (d *testDeps) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.log.Write(b)
+}
+
+// logCount returns how many times s was logged.
+func (d *testDeps) logCount(s string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return strings.Count(d.log.String(), s)
+}
+
+// Now implements pipeline.Dependencies.
+func (d *testDeps) Now() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.now
+}
+
+func (d *testDeps) advance(duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.now = d.now.Add(duration)
+}
+
+// testPipeline is a pipeline processing the events of a fake watcher.
+type testPipeline struct {
+	*pipeline.Pipeline
+	watcher *watchdirs.FakeWatcher
+	deps    *testDeps
+}
+
+func startPipeline(t *testing.T, cfg runtimeconfig.Config) *testPipeline {
+	p := &testPipeline{
+		watcher: watchdirs.NewFakeWatcher(),
+		deps:    newTestDeps(),
+	}
+	assert.NoError(t, p.watcher.Add(cfg.WorkingDirectory()))
+	filters := watchdirs.Filters{
+		IncludeFileRegexes: cfg.IncludeFileRegexes(),
+		ExcludeFileRegexes: cfg.ExcludeFileRegexes(),
+	}
+	p.Pipeline = pipeline.Start(
+		p.deps,
+		pipeline.Settings{Config: cfg, Filters: filters, Restart: filters},
+		p.watcher.Events(),
+		p.watcher.Errors(),
+	)
+	return p
+}
+
+// stop closes the channels of the fake watcher and waits for the
+// pipeline to stop.
+func (p *testPipeline) stop() {
+	p.watcher.Close()
+	p.Stop()
+}
+
+// readLines returns the lines of the file at path, such as those written
+// by the server command of each start.
+func readLines(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// writeFiles writes each of files, by name, to the directory dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+// waitForLines waits for the file at path to hold n lines or more.
+func waitForLines(t *testing.T, path string, n int) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		return len(readLines(t, path)) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPipeline(t *testing.T) {
+	tempDir := t.TempDir()
+	// The build cache is kept in the working directory.
+	t.Chdir(tempDir)
+	startsPath := filepath.Join(tempDir, "starts.log")
+	script := `echo "$PROCROTATOR_RESTART_COUNT $PROCROTATOR_CHANGED_FILES" >> starts.log
+exec sleep 60
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "server.sh"), []byte(script), 0o644))
+	cfg, err := runtimeconfig.Build([]string{
+		"-d", tempDir,
+		"-s", "sh server.sh",
+		"-i", `\.go$`,
+		"-e", `_test\.go$`,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	mainFile := filepath.Join(tempDir, "main.go")
+	utilFile := filepath.Join(tempDir, "util.go")
+	p := startPipeline(t, cfg)
+	defer p.stop()
+	waitForStarts := func(expected ...string) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return len(readLines(t, startsPath)) >= len(expected)
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, expected, readLines(t, startsPath))
+	}
+	waitForStarts("0 ")
+
+	// Changes to files that are not included, or that are excluded, and
+	// operations that are not reported, do not restart the server.
+	p.deps.advance(time.Minute)
+	p.watcher.Send(filepath.Join(tempDir, "README.md"), watchdirs.WRITE)
+	p.watcher.Send(filepath.Join(tempDir, "main_test.go"), watchdirs.WRITE)
+	p.watcher.Send(mainFile, watchdirs.CHMOD)
+	p.watcher.SendError(assert.AnError)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	waitForStarts("0 ", "1 "+mainFile)
+
+	// Changes within the minimum restart interval are collected for the
+	// next restart.
+	p.watcher.Send(utilFile, watchdirs.CREATE)
+	assert.Eventually(t, func() bool {
+		return p.deps.logCount("Skipping restart") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	waitForStarts("0 ", "1 "+mainFile, "2 "+utilFile+string(os.PathListSeparator)+mainFile)
+}
+
+func TestPipelineFailedPreamble(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+	startsPath := filepath.Join(tempDir, "starts.log")
+	generatePath := filepath.Join(tempDir, "generate.log")
+	failPath := filepath.Join(tempDir, "fail")
+	writeFiles(t, tempDir, map[string]string{
+		"server.sh": `echo "$PROCROTATOR_RESTART_COUNT $PROCROTATOR_CHANGED_FILES" >> starts.log
+exec sleep 60
+`,
+		"generate.sh": `echo generate >> generate.log
+test ! -f fail
+`,
+		"procrotator.toml": `include_file_regexes = ["\\.go$", "\\.proto$"]
+preamble_commands = [
+  { command = "sh generate.sh", include_file_regexes = ["\\.proto$"] },
+]
+server_command = "sh server.sh"
+`,
+	})
+	cfg, err := runtimeconfig.Build([]string{"-config", filepath.Join(tempDir, "procrotator.toml")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	protoFile := filepath.Join(tempDir, "api.proto")
+	mainFile := filepath.Join(tempDir, "main.go")
+	p := startPipeline(t, cfg)
+	defer p.stop()
+	waitForLines(t, startsPath, 1)
+
+	// The routed preamble command fails, so the server is not restarted.
+	assert.NoError(t, os.WriteFile(failPath, nil, 0o644))
+	p.deps.advance(time.Minute)
+	p.watcher.Send(protoFile, watchdirs.WRITE)
+	assert.Eventually(t, func() bool {
+		return p.deps.logCount("Error running preamble command") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The failed change stays pending, so the routed preamble command runs
+	// again along with the next change even though it does not match it.
+	assert.NoError(t, os.Remove(failPath))
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	waitForLines(t, startsPath, 2)
+	assert.Len(t, readLines(t, generatePath), 3)
+	assert.Equal(t, []string{"0 ", "1 " + protoFile + string(os.PathListSeparator) + mainFile}, readLines(t, startsPath))
+}
+
+func TestPipelineQuitSignal(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+	startsPath := filepath.Join(tempDir, "starts.log")
+	writeFiles(t, tempDir, map[string]string{
+		"server.sh": `echo "$PROCROTATOR_RESTART_COUNT" >> starts.log
+exec sleep 60
+`,
+		"procrotator.toml": `include_file_regexes = ["\\.go$"]
+server_command = "sh server.sh"
+quit_signal = "SIGTERM"
+`,
+	})
+	cfg, err := runtimeconfig.Build([]string{"-config", filepath.Join(tempDir, "procrotator.toml")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	mainFile := filepath.Join(tempDir, "main.go")
+	p := startPipeline(t, cfg)
+	defer p.stop()
+	waitForLines(t, startsPath, 1)
+
+	// A server killed by the quit signal stopped cleanly, so it restarts
+	// on every change.
+	for i := range 2 {
+		p.deps.advance(time.Minute)
+		p.watcher.Send(mainFile, watchdirs.WRITE)
+		waitForLines(t, startsPath, i+2)
+	}
+	assert.Equal(t, []string{"0", "1", "2"}, readLines(t, startsPath))
+	assert.Zero(t, p.deps.logCount("Error"))
+}
+
+// rotationServer is a server script logging its starts and stops along
+// with its restart count. It exits right away while the file "fail"
+// exists.
+const rotationServer = `n=$PROCROTATOR_RESTART_COUNT
+if [ -f fail ]; then
+  echo "exit $n" >> events.log
+  exit 1
+fi
+echo "start $n" >> events.log
+trap 'echo "stop $n" >> events.log; exit 0' INT TERM
+while true; do sleep 0.01; done
+`
+
+func TestPipelineStartThenStop(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+	eventsPath := filepath.Join(tempDir, "events.log")
+	writeFiles(t, tempDir, map[string]string{
+		"server.sh": rotationServer,
+		"procrotator.toml": `include_file_regexes = ["\\.go$"]
+server_command = "sh server.sh"
+restart_strategy = "start-then-stop"
+readiness_command = "test -f ready"
+readiness_timeout = "30s"
+`,
+	})
+	cfg, err := runtimeconfig.Build([]string{"-config", filepath.Join(tempDir, "procrotator.toml")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	mainFile := filepath.Join(tempDir, "main.go")
+	p := startPipeline(t, cfg)
+	waitForLines(t, eventsPath, 1)
+
+	// The previous instance keeps running until the new one is ready.
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	waitForLines(t, eventsPath, 2)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"start 0", "start 1"}, readLines(t, eventsPath))
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "ready"), nil, 0o644))
+	waitForLines(t, eventsPath, 3)
+	assert.Equal(t, []string{"start 0", "start 1", "stop 0"}, readLines(t, eventsPath))
+
+	p.stop()
+	assert.Equal(t, []string{"start 0", "start 1", "stop 0", "stop 1"}, readLines(t, eventsPath))
+}
+
+func TestPipelineStartThenStopNotReady(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+	eventsPath := filepath.Join(tempDir, "events.log")
+	failPath := filepath.Join(tempDir, "fail")
+	writeFiles(t, tempDir, map[string]string{
+		"server.sh": rotationServer,
+		"procrotator.toml": `include_file_regexes = ["\\.go$"]
+server_command = "sh server.sh"
+restart_strategy = "start-then-stop"
+readiness_command = "test -f ready"
+readiness_timeout = "1s"
+`,
+	})
+	cfg, err := runtimeconfig.Build([]string{"-config", filepath.Join(tempDir, "procrotator.toml")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	mainFile := filepath.Join(tempDir, "main.go")
+	p := startPipeline(t, cfg)
+	defer p.stop()
+	waitForLines(t, eventsPath, 1)
+	notReady := func(n int) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			return p.deps.logCount("New child process is not ready") == n
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// A new instance exiting early is discarded.
+	assert.NoError(t, os.WriteFile(failPath, nil, 0o644))
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	notReady(1)
+	assert.Equal(t, []string{"start 0", "exit 1"}, readLines(t, eventsPath))
+
+	// So is a new instance that does not become ready in time.
+	assert.NoError(t, os.Remove(failPath))
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	notReady(2)
+	waitForLines(t, eventsPath, 4)
+	assert.Equal(t, []string{"start 0", "exit 1", "start 1", "stop 1"}, readLines(t, eventsPath))
+
+	// The first instance kept running, and is replaced once a new one is
+	// ready.
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "ready"), nil, 0o644))
+	p.deps.advance(time.Minute)
+	p.watcher.Send(mainFile, watchdirs.WRITE)
+	waitForLines(t, eventsPath, 6)
+	assert.Equal(
+		t,
+		[]string{"start 0", "exit 1", "start 1", "stop 1", "start 1", "stop 0"},
+		readLines(t, eventsPath),
+	)
+}
@@ -0,0 +1,141 @@
+// Package preamble runs preamble commands according to their dependency
+// graph.
+package preamble
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakewan/go-procrotator/buildcache"
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+type taskStatus int
+
+const (
+	taskStatusSucceeded taskStatus = iota
+	taskStatusFailed
+	taskStatusSkipped
+	taskStatusBlocked
+	taskStatusCached
+)
+
+func (r taskStatus) String() string {
+	return [...]string{"ok", "failed", "skipped", "blocked", "cached"}[r]
+}
+
+func (r taskStatus) EnumIndex() int {
+	return int(r)
+}
+
+type taskResult struct {
+	status   taskStatus
+	duration time.Duration
+	err      error
+}
+
+// RunFunc runs a single preamble command.
+type RunFunc func(p runtimeconfig.Preamble) error
+
+// Run runs preambles, starting each one as soon as the preambles it
+// depends on have completed, so independent preambles run in parallel.
+// Preambles whose file patterns match none of changedFiles, which are
+// also matched relative to the roots of the configuration, are skipped
+// and count as completed for their dependents. When a preamble fails,
+// the preambles depending on it do not run and Run returns the error of
+// the first failed preamble in list order.
+//
+// When cache is not nil, preambles declaring inputs are skipped if their
+// inputs and outputs are unchanged since their last successful run.
+//
+// The dependencies are expected to have been validated when the
+// configuration was built.
+func Run(
+	l logger.Logger,
+	preambles []runtimeconfig.Preamble,
+	changedFiles []string,
+	roots []string,
+	cache *buildcache.Cache,
+	run RunFunc,
+) error {
+	if len(preambles) == 0 {
+		return nil
+	}
+	indexes := make(map[string]int, len(preambles))
+	done := make([]chan struct{}, len(preambles))
+	for i, p := range preambles {
+		indexes[p.Name] = i
+		done[i] = make(chan struct{})
+	}
+	results := make([]taskResult, len(preambles))
+	var wg sync.WaitGroup
+	for i, p := range preambles {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+			for _, d := range p.DependsOn {
+				j := indexes[d]
+				<-done[j]
+				if s := results[j].status; s == taskStatusFailed || s == taskStatusBlocked {
+					results[i] = taskResult{status: taskStatusBlocked}
+					return
+				}
+			}
+			if !p.ShouldRun(changedFiles, roots) {
+				l.Errorf(logger.DEBUG, "Skipping preamble command with no matching changes: %s", p.Command)
+				results[i] = taskResult{status: taskStatusSkipped}
+				return
+			}
+			startedAt := time.Now()
+			var inputsHash string
+			if cache != nil && p.Cacheable() {
+				if fresh, h, err := cache.Check(p); err != nil {
+					l.Errorf(logger.WARNING, "Error checking build cache for %s: %s", p.Name, err)
+				} else if fresh {
+					l.Errorf(logger.INFO, "Preamble %s: cached", p.Name)
+					results[i] = taskResult{
+						status:   taskStatusCached,
+						duration: time.Since(startedAt),
+					}
+					return
+				} else {
+					inputsHash = h
+				}
+			}
+			err := run(p)
+			results[i] = taskResult{
+				status:   taskStatusSucceeded,
+				duration: time.Since(startedAt),
+				err:      err,
+			}
+			if err != nil {
+				results[i].status = taskStatusFailed
+			} else if inputsHash != "" {
+				if err := cache.Record(p, inputsHash); err != nil {
+					l.Errorf(logger.WARNING, "Error updating build cache for %s: %s", p.Name, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	logSummary(l, preambles, results)
+	for i, r := range results {
+		if r.err != nil {
+			return fmt.Errorf("preamble %s: %w", preambles[i].Name, r.err)
+		}
+	}
+	return nil
+}
+
+func logSummary(l logger.Logger, preambles []runtimeconfig.Preamble, results []taskResult) {
+	var b strings.Builder
+	b.WriteString("Preamble summary:")
+	for i, r := range results {
+		fmt.Fprintf(&b, "\n  %-8s %10s  %s", r.status, r.duration.Round(time.Millisecond), preambles[i].Name)
+	}
+	l.Errorf(logger.INFO, "%s", b.String())
+}
@@ -0,0 +1,80 @@
+package preamble_test
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/preamble"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	l := logger.NewLogger("test", io.Discard)
+	preambles := []runtimeconfig.Preamble{
+		{Name: "protoc", Command: "protoc"},
+		{Name: "generate", Command: "go generate", DependsOn: []string{"protoc"}},
+		{Name: "build", Command: "go build", DependsOn: []string{"generate"}},
+		{Name: "npm", Command: "npm run build", DependsOn: []string{}},
+	}
+
+	t.Run("dependencies complete first", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			order []string
+		)
+		err := preamble.Run(l, preambles, nil, nil, nil, func(p runtimeconfig.Preamble) error {
+			if p.Name == "npm" {
+				// Runs in parallel with the chain, so finishes last.
+				time.Sleep(50 * time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, p.Name)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"protoc", "generate", "build", "npm"}, order)
+	})
+
+	t.Run("failure blocks dependents", func(t *testing.T) {
+		var (
+			mu  sync.Mutex
+			ran []string
+		)
+		err := preamble.Run(l, preambles, nil, nil, nil, func(p runtimeconfig.Preamble) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, p.Name)
+			if p.Name == "generate" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		assert.ErrorContains(t, err, "preamble generate: boom")
+		assert.ElementsMatch(t, []string{"protoc", "generate", "npm"}, ran)
+	})
+
+	t.Run("skipped preambles satisfy dependents", func(t *testing.T) {
+		routed := []runtimeconfig.Preamble{
+			{
+				Name:               "protoc",
+				Command:            "protoc",
+				IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.proto$`)},
+			},
+			{Name: "build", Command: "go build", DependsOn: []string{"protoc"}},
+		}
+		var ran []string
+		err := preamble.Run(l, routed, []string{"main.go"}, nil, nil, func(p runtimeconfig.Preamble) error {
+			ran = append(ran, p.Name)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"build"}, ran)
+	})
+}
@@ -0,0 +1,78 @@
+// Package reload rebuilds the configuration when the config file
+// changes, so that a running go-procrotator can apply it.
+package reload
+
+import (
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+type Dependencies interface {
+	Logger() logger.Logger
+}
+
+// BuildFunc builds the configuration from the current config file.
+type BuildFunc func() (runtimeconfig.Config, error)
+
+// quietPeriod is how long the reloader waits for further changes to the
+// config file before rebuilding the configuration, since editors often
+// save a file in several steps.
+const quietPeriod = 200 * time.Millisecond
+
+// StartReloader receives watcher events from changes and, after changes
+// to any of the config files at paths, sends the configuration returned
+// by build to out. An invalid configuration is logged and not sent, so the
+// current configuration stays in effect.
+//
+// The reloader runs until changes is closed.
+func StartReloader(
+	deps Dependencies,
+	paths []string,
+	build BuildFunc,
+	changes <-chan watchdirs.WatcherEvent,
+	out chan<- runtimeconfig.Config,
+	done chan<- bool,
+) {
+	defer func() {
+		done <- true
+	}()
+	l := deps.Logger()
+	paths = slices.Clone(paths)
+	for i, p := range paths {
+		paths[i] = filepath.Clean(p)
+	}
+	var (
+		quiet   <-chan time.Time
+		changed string
+	)
+	for {
+		select {
+		case ev, ok := <-changes:
+			if !ok {
+				return
+			}
+			if p := filepath.Clean(ev.Path); slices.Contains(paths, p) && slices.ContainsFunc(ev.Ops, isContentOp) {
+				changed = p
+				quiet = time.After(quietPeriod)
+			}
+		case <-quiet:
+			quiet = nil
+			if cfg, err := build(); err != nil {
+				l.Errorf(logger.ERROR, "Keeping the current configuration: %s", err)
+			} else {
+				l.Errorf(logger.NOTICE, "Reloaded configuration after changes to %s", changed)
+				out <- cfg
+			}
+		}
+	}
+}
+
+// isContentOp reports whether op may change the contents of a file.
+func isContentOp(op watchdirs.WatcherEventOp) bool {
+	return op != watchdirs.CHMOD
+}
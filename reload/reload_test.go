@@ -0,0 +1,86 @@
+package reload_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/reload"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+type testDeps struct{}
+
+// Logger implements reload.Dependencies.
+func (testDeps) Logger() logger.Logger {
+	return logger.NewLogger("test", io.Discard)
+}
+
+func TestStartReloader(t *testing.T) {
+	const (
+		path     = "/app/procrotator.toml"
+		basePath = "/shared/procrotator.base.toml"
+	)
+	// build reports each call on built, so that the test can wait for
+	// the reloader to build.
+	results := []error{nil, errors.New("invalid"), nil}
+	built := make(chan int, len(results))
+	builds := 0
+	build := func() (runtimeconfig.Config, error) {
+		err := results[builds]
+		builds++
+		built <- builds
+		if err != nil {
+			return nil, err
+		}
+		return runtimeconfig.Build([]string{"-s", fmt.Sprintf("./app%d", builds)})
+	}
+	changes := make(chan watchdirs.WatcherEvent)
+	out := make(chan runtimeconfig.Config, 10)
+	done := make(chan bool, 1)
+	go reload.StartReloader(testDeps{}, []string{path, basePath}, build, changes, out, done)
+	receive := func() runtimeconfig.Config {
+		t.Helper()
+		select {
+		case cfg := <-out:
+			return cfg
+		case <-time.After(time.Second):
+			assert.FailNow(t, "Configuration not reloaded")
+			return nil
+		}
+	}
+
+	// A burst of changes to the config file builds once.
+	changes <- watchdirs.WatcherEvent{Path: path, Ops: []watchdirs.WatcherEventOp{watchdirs.CREATE}}
+	changes <- watchdirs.WatcherEvent{Path: path, Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}
+	changes <- watchdirs.WatcherEvent{Path: "/app/main.go", Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}
+	assert.Equal(t, "./app1", receive().ServerCommand())
+	assert.Equal(t, 1, <-built)
+
+	// Other files and attribute changes are ignored. Nothing is built
+	// within twice the quiet period.
+	changes <- watchdirs.WatcherEvent{Path: "/app/main.go", Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}
+	changes <- watchdirs.WatcherEvent{Path: path, Ops: []watchdirs.WatcherEventOp{watchdirs.CHMOD}}
+	select {
+	case n := <-built:
+		assert.Fail(t, "Configuration rebuilt after ignored changes", "build %d", n)
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	// An invalid configuration is not sent.
+	changes <- watchdirs.WatcherEvent{Path: path, Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}
+	assert.Equal(t, 2, <-built)
+
+	// Changes to an extended config file reload the configuration too. The
+	// configuration received is the one built after the invalid one.
+	changes <- watchdirs.WatcherEvent{Path: basePath, Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}
+	assert.Equal(t, "./app3", receive().ServerCommand())
+	assert.Equal(t, 3, <-built)
+	close(changes)
+	<-done
+}
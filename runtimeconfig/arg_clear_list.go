@@ -0,0 +1,16 @@
+package runtimeconfig
+
+type argClearList struct {
+	argname  string
+	argusage string
+}
+
+// name implements argDef.
+func (a argClearList) name() string {
+	return a.argname
+}
+
+// usage implements argDef.
+func (a argClearList) usage() string {
+	return a.argusage
+}
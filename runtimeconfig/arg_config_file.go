@@ -0,0 +1,42 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type argConfigFile struct {
+	value *string
+}
+
+// name implements argDef.
+func (a argConfigFile) name() string {
+	return "config"
+}
+
+// stringFunc implements argDefWithStringFunc.
+func (a argConfigFile) stringFunc() func(string) error {
+	return func(s string) error {
+		if abs, err := filepath.Abs(s); err != nil {
+			return fmt.Errorf("obtaining absolute path from %s: %s", s, err)
+		} else if fi, err := os.Stat(abs); err != nil {
+			return fmt.Errorf("obtaining file information for %s: %w", abs, err)
+		} else if fi.IsDir() {
+			return fmt.Errorf("%s is a directory", abs)
+		} else if !slices.Contains(configFileExtensions(), filepath.Ext(abs)) {
+			return fmt.Errorf("%s is not a .toml, .yaml, .yml or .json file", abs)
+		} else {
+			*a.value = abs
+			return nil
+		}
+	}
+}
+
+// usage implements argDef.
+func (a argConfigFile) usage() string {
+	return `The path of the config file, used instead of searching for one.
+
+The working directory defaults to the directory of the config file.`
+}
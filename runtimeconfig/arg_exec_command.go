@@ -0,0 +1,13 @@
+package runtimeconfig
+
+type argExecCommand struct{}
+
+// name implements argDef.
+func (a argExecCommand) name() string {
+	return "execcommand"
+}
+
+// usage implements argDef.
+func (a argExecCommand) usage() string {
+	return `The command to run to completion on every change in exec mode`
+}
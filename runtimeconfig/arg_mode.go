@@ -0,0 +1,42 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+type argMode struct {
+	value *string
+}
+
+// name implements argDef.
+func (a argMode) name() string {
+	return "mode"
+}
+
+// stringFunc implements argDefWithStringFunc.
+func (a argMode) stringFunc() func(string) error {
+	return func(s string) error {
+		if _, err := parseMode(s); err != nil {
+			return err
+		}
+		*a.value = s
+		return nil
+	}
+}
+
+// usage implements argDef.
+func (a argMode) usage() string {
+	modes := make([]string, 0, len(allModes()))
+	for _, m := range allModes() {
+		modes = append(modes, m.String())
+	}
+	return fmt.Sprintf(
+		`What to run when files change.
+
+Expected values: %s
+
+The default is server.`,
+		strings.Join(modes, ", "),
+	)
+}
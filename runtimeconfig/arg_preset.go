@@ -0,0 +1,42 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+type argPreset struct {
+	value *string
+}
+
+// name implements argDef.
+func (a argPreset) name() string {
+	return "preset"
+}
+
+// stringFunc implements argDefWithStringFunc.
+func (a argPreset) stringFunc() func(string) error {
+	return func(s string) error {
+		if _, err := parsePreset(s); err != nil {
+			return err
+		}
+		*a.value = s
+		return nil
+	}
+}
+
+// usage implements argDef.
+func (a argPreset) usage() string {
+	presetNames := make([]string, 0, len(AllPresets()))
+	for _, p := range AllPresets() {
+		presetNames = append(presetNames, p.String())
+	}
+	return fmt.Sprintf(
+		`A preset supplying default settings for a kind of project.
+
+Expected values: %s
+
+Run "go-procrotator presets" to list the settings of each preset.`,
+		strings.Join(presetNames, ", "),
+	)
+}
@@ -0,0 +1,16 @@
+package runtimeconfig
+
+type argProfile struct{}
+
+// name implements argDef.
+func (a argProfile) name() string {
+	return "profile"
+}
+
+// usage implements argDef.
+func (a argProfile) usage() string {
+	return `The name of a [profile.<name>] table of the config file whose settings
+override the rest of the file.
+
+May be specified multiple times. Profiles are applied in order.`
+}
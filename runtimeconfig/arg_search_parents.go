@@ -0,0 +1,16 @@
+package runtimeconfig
+
+type argSearchParents struct{}
+
+// name implements argDef.
+func (a argSearchParents) name() string {
+	return "searchparents"
+}
+
+// usage implements argDef.
+func (a argSearchParents) usage() string {
+	return `Search the parent directories of the working directory for a config file
+when the working directory has none.
+
+The working directory defaults to the directory of the config file found.`
+}
@@ -0,0 +1,42 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+type argWatcher struct {
+	value *string
+}
+
+// name implements argDef.
+func (a argWatcher) name() string {
+	return "watcher"
+}
+
+// stringFunc implements argDefWithStringFunc.
+func (a argWatcher) stringFunc() func(string) error {
+	return func(s string) error {
+		if _, err := parseWatcherBackend(s); err != nil {
+			return err
+		}
+		*a.value = s
+		return nil
+	}
+}
+
+// usage implements argDef.
+func (a argWatcher) usage() string {
+	backends := make([]string, 0, len(allWatcherBackends()))
+	for _, w := range allWatcherBackends() {
+		backends = append(backends, w.String())
+	}
+	return fmt.Sprintf(
+		`How changes to files are detected.
+
+Expected values: %s
+
+The default is auto, which polls when fsnotify is unavailable or unreliable.`,
+		strings.Join(backends, ", "),
+	)
+}
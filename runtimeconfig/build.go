@@ -6,14 +6,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"slices"
+	"strings"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/jakewan/go-procrotator/logger"
 )
 
+const defaultReadinessTimeout = 30 * time.Second
+
 var (
 	errConfigFileNotFound = errors.New("no config file found")
 )
@@ -28,20 +34,66 @@ type (
 		stringFunc() func(string) error
 	}
 	tomlConfig struct {
-		IncludeFileRegexes []string `toml:"include_file_regexes"`
-		ExcludeFileRegexes []string `toml:"exclude_file_regexes"`
-		PreambleCommands   []string `toml:"preamble_commands"`
-		ServerCommand      string   `toml:"server_command"`
-		QuitSignal         string   `toml:"quit_signal"`
+		IncludeFileRegexes []string       `toml:"include_file_regexes"`
+		ExcludeFileRegexes []string       `toml:"exclude_file_regexes"`
+		PreambleCommands   []preambleSpec `toml:"preamble_commands"`
+		ServerCommand      string         `toml:"server_command"`
+		QuitSignal         string         `toml:"quit_signal"`
 		quitSignalInt      syscall.Signal
 		LogLevel           string `toml:"log_level"`
+		RestartStrategy    string `toml:"restart_strategy"`
+		restartStrategy    RestartStrategy
+		ReadinessCommand   string `toml:"readiness_command"`
+		ReadinessTimeout   string `toml:"readiness_timeout"`
+		readinessTimeout   time.Duration
+		Env                map[string]string `toml:"env"`
+		EnvFiles           []string          `toml:"env_file"`
+		ClearEnv           bool              `toml:"clear_env"`
+		OnChange           []onChangeSpec    `toml:"on_change"`
+		Mode               string            `toml:"mode"`
+		ExecCommand        string            `toml:"exec_command"`
+		Preset             string            `toml:"preset"`
+		IgnoreDirectories  []string          `toml:"ignore_directories"`
+		WatchPaths         []string          `toml:"watch_paths"`
+		Watcher            string            `toml:"watcher"`
+		watcher            WatcherBackend
+		PollInterval       string `toml:"poll_interval"`
+		pollInterval       time.Duration
+		PollHash           bool                      `toml:"poll_hash"`
+		PollOverWatchLimit bool                      `toml:"poll_over_watch_limit"`
+		Extends            []string                  `toml:"extends"`
+		MergeStrategy      map[string]string         `toml:"merge_strategy"`
+		Profile            map[string]toml.Primitive `toml:"profile"`
+		meta               toml.MetaData
+		path               string
+		absPath            string
+		doc                string
+		lines              map[string]int
+		// bases are the config files listed in extends.
+		bases []*tomlConfig
+		// converted is set when the file was converted to TOML from
+		// another format.
+		converted bool
+		// profiles are the names of the profiles applied, in order.
+		profiles []string
+		// envVars maps the settings overridden by the environment to
+		// the names of the variables.
+		envVars map[string]string
 	}
 )
 
-func configFileNames() []string {
+// ConfigFileNames returns the names of the config files searched for in the
+// working directory. At most one of them may exist.
+func ConfigFileNames() []string {
 	return []string{
 		".procrotator.toml",
 		"procrotator.toml",
+		".procrotator.yaml",
+		"procrotator.yaml",
+		".procrotator.yml",
+		"procrotator.yml",
+		".procrotator.json",
+		"procrotator.json",
 	}
 }
 
@@ -74,6 +126,13 @@ func addFlagsetStringVar(
 	}
 }
 
+func addFlagsetBoolVar(f *flag.FlagSet, boolVar *bool, a argDef, aliases ...string) {
+	f.BoolVar(boolVar, a.name(), false, a.usage())
+	for _, alias := range aliases {
+		f.BoolVar(boolVar, alias, false, fmt.Sprintf("Alias of -%s", a.name()))
+	}
+}
+
 func addFlagsetStringVarAdder(
 	f *flag.FlagSet,
 	target *[]string,
@@ -94,22 +153,47 @@ func addFlagsetStringVarAdder(
 	}
 }
 
+// Build resolves the configuration from the defaults, the config file,
+// the PROCROTATOR_ environment variables, any preset and the command line
+// arguments args. Errors caused by an invalid configuration, including
+// invalid arguments, are of type *ConfigError. Requesting help with -h
+// prints the usage and returns flag.ErrHelp.
 func Build(args []string) (Config, error) {
 	var (
 		logLevel           logger.LogLevel
 		wd                 string
+		configFile         string
+		searchParents      bool
+		profiles           []string
 		serverCommand      string
+		execCommand        string
+		mode               string
+		watcher            string
+		preset             string
 		includeFileRegexes []regexp.Regexp
 		excludeFileRegexes []regexp.Regexp
-		preambleCommands   []string
+		// Regexes added to those of the config file, and whether to
+		// clear those of the config file.
+		addIncludeFileRegexes   []regexp.Regexp
+		addExcludeFileRegexes   []regexp.Regexp
+		clearIncludeFileRegexes bool
+		clearExcludeFileRegexes bool
+		preambleCommands        []string
 	)
 
 	// Figure out the working directory first because it would contain any
 	// configuration file.
-	f := flag.NewFlagSet("go-procrotator", flag.ExitOnError)
+	f := flag.NewFlagSet("go-procrotator", flag.ContinueOnError)
 	addFlagsetFuncs(f, argDirectory{value: &wd}, "d")
+	addFlagsetFuncs(f, argConfigFile{value: &configFile}, "c")
+	addFlagsetBoolVar(f, &searchParents, argSearchParents{})
+	addFlagsetStringVarAdder(f, &profiles, argProfile{})
 	addFlagsetFuncs(f, argLogLevel{stream: errStream, value: &logLevel}, "l")
 	addFlagsetStringVar(f, &serverCommand, "", argServerCommand{}, "s")
+	addFlagsetStringVar(f, &execCommand, "", argExecCommand{}, "x")
+	addFlagsetFuncs(f, argMode{value: &mode})
+	addFlagsetFuncs(f, argWatcher{value: &watcher})
+	addFlagsetFuncs(f, argPreset{value: &preset})
 	addFlagsetFuncs(
 		f,
 		argMultiRegex{
@@ -132,113 +216,644 @@ May be specified multiple times.`,
 		},
 		"e",
 	)
+	addFlagsetFuncs(
+		f,
+		argMultiRegex{
+			argname: "addincludefileregexes",
+			argusage: `A regular expression matching files to observe, in addition to those
+of the config file.
+
+May be specified multiple times.`,
+			regexes: &addIncludeFileRegexes,
+		},
+		"i+",
+	)
+	addFlagsetFuncs(
+		f,
+		argMultiRegex{
+			argname: "addexcludefileregexes",
+			argusage: `A regular expression matching files to exclude from observation, in
+addition to those of the config file.
+
+May be specified multiple times.`,
+			regexes: &addExcludeFileRegexes,
+		},
+		"e+",
+	)
+	addFlagsetBoolVar(
+		f,
+		&clearIncludeFileRegexes,
+		argClearList{
+			argname:  "clearincludefileregexes",
+			argusage: "Ignore the include file regexes of the config file.",
+		},
+	)
+	addFlagsetBoolVar(
+		f,
+		&clearExcludeFileRegexes,
+		argClearList{
+			argname:  "clearexcludefileregexes",
+			argusage: "Ignore the exclude file regexes of the config file.",
+		},
+	)
 	addFlagsetStringVarAdder(f, &preambleCommands, argPreambleCommand{}, "p")
 
-	if err := f.Parse(args); err != nil {
+	if err := f.Parse(args); errors.Is(err, flag.ErrHelp) {
 		return nil, err
+	} else if err != nil {
+		return nil, invalid(err)
 	}
 
 	result := config{
 		logLevel:         logger.INFO,
 		quitSignal:       syscall.SIGINT,
 		workingDirectory: wd,
+		restartStrategy:  StopThenStart,
+		readinessTimeout: defaultReadinessTimeout,
+		pollInterval:     defaultPollInterval,
+		origins:          map[string]Origin{},
 	}
 
+	// Tracks which preambles declared depends_on, as opposed to
+	// implicitly depending on the preceding preamble.
+	var preambleDependsOnSet []bool
+
 	// Try to find a config file.
-	if d, err := readConfigFile(wd); err != nil {
-		if errors.Is(err, errConfigFileNotFound) {
-			// No configuration file found.
-			// Obtain settings from the command line.
-			result.serverCommand = serverCommand
-			result.includeFileRegexes = includeFileRegexes
-			result.excludeFileRegexes = excludeFileRegexes
-			result.preambleCommands = preambleCommands
-		} else {
+	d, err := readConfigFile(wd, configFile, searchParents, profiles)
+	if err != nil {
+		var ce *ConfigError
+		if errors.As(err, &ce) {
+			return nil, err
+		} else if !errors.Is(err, errConfigFileNotFound) {
 			return nil, fmt.Errorf("reading config file: %w", err)
+		} else if len(profiles) > 0 {
+			return nil, invalid(fmt.Errorf("profile %s requested but no config file found", profiles[0]))
 		}
+		// No configuration file found. Settings come from the environment,
+		// any preset and the command line.
+		d = &tomlConfig{}
 	} else {
-		// Load config from the file settings. Then override with any
-		// command line arguments that were provided.
-		for _, s := range d.IncludeFileRegexes {
-			if r, err := regexp.Compile(s); err != nil {
-				return nil, fmt.Errorf("parsing include file expressions: %w", err)
-			} else {
-				result.includeFileRegexes = append(result.includeFileRegexes, *r)
-			}
+		result.configFile = d.path
+		result.baseConfigFiles = d.baseFiles()
+		result.profiles = d.profiles
+		if wd == "" && (configFile != "" || searchParents) {
+			result.workingDirectory = filepath.Dir(d.path)
 		}
-		for _, s := range d.ExcludeFileRegexes {
-			if r, err := regexp.Compile(s); err != nil {
-				return nil, fmt.Errorf("parsing exclude file expressions: %w", err)
-			} else {
-				result.excludeFileRegexes = append(result.excludeFileRegexes, *r)
-			}
+	}
+	// Environment variables override the file settings.
+	if err := d.applyEnv(); err != nil {
+		return nil, err
+	} else if err := d.parseValues(); err != nil {
+		return nil, err
+	}
+	// Load config from the file and environment settings.
+	for _, key := range settingKeys() {
+		if o, ok := d.origin(key); ok {
+			result.origins[key] = o
 		}
-		result.serverCommand = d.ServerCommand
-		result.preambleCommands = d.PreambleCommands
-		if d.LogLevel != "" {
-			if i := slices.IndexFunc(
-				logger.AllLevels(),
-				func(l logger.LogLevel) bool {
-					return l.String() == d.LogLevel
-				},
-			); i < 0 {
-				return nil, fmt.Errorf(
-					"config file specifies unexpected log level: %s",
-					d.LogLevel,
-				)
-			} else {
-				result.logLevel = logger.AllLevels()[i]
-			}
+	}
+	if result.includeFileRegexes, err = compileRegexes(d.IncludeFileRegexes); err != nil {
+		return nil, d.errorAt("include_file_regexes", fmt.Errorf("parsing include file expressions: %w", err))
+	}
+	if result.excludeFileRegexes, err = compileRegexes(d.ExcludeFileRegexes); err != nil {
+		return nil, d.errorAt("exclude_file_regexes", fmt.Errorf("parsing exclude file expressions: %w", err))
+	}
+	result.ignoreDirectories = d.IgnoreDirectories
+	if slices.Contains(d.WatchPaths, "") {
+		return nil, d.errorAt("watch_paths", errors.New("watch_paths must not contain empty paths"))
+	}
+	result.watchPaths = d.WatchPaths
+	result.serverCommand = d.ServerCommand
+	result.execCommand = d.ExecCommand
+	if d.Mode != "" {
+		if m, err := parseMode(d.Mode); err != nil {
+			return nil, d.errorAt("mode", err)
+		} else {
+			result.mode = m
+		}
+	}
+	if d.Preset != "" {
+		if p, err := parsePreset(d.Preset); err != nil {
+			return nil, d.errorAt("preset", err)
+		} else {
+			result.preset = p
+		}
+	}
+	for _, p := range d.PreambleCommands {
+		if built, err := p.build(); err != nil {
+			return nil, d.errorAt("preamble_commands", err)
+		} else {
+			result.preambles = append(result.preambles, built)
+			preambleDependsOnSet = append(preambleDependsOnSet, p.dependsOnSet)
+		}
+	}
+	if d.LogLevel != "" {
+		if i := slices.IndexFunc(
+			logger.AllLevels(),
+			func(l logger.LogLevel) bool {
+				return l.String() == d.LogLevel
+			},
+		); i < 0 {
+			return nil, d.errorAt("log_level", fmt.Errorf(
+				"unexpected log level: %s",
+				d.LogLevel,
+			))
+		} else {
+			result.logLevel = logger.AllLevels()[i]
+		}
+	}
+	result.quitSignal = d.quitSignalInt
+	result.restartStrategy = d.restartStrategy
+	result.readinessCommand = d.ReadinessCommand
+	result.readinessTimeout = d.readinessTimeout
+	result.env = d.Env
+	result.envFiles = d.EnvFiles
+	result.clearEnv = d.ClearEnv
+	result.watcher = d.watcher
+	result.pollInterval = d.pollInterval
+	result.pollHash = d.PollHash
+	result.pollOverWatchLimit = d.PollOverWatchLimit
+	for _, o := range d.OnChange {
+		if built, err := o.build(); err != nil {
+			return nil, d.errorAt("on_change", err)
+		} else {
+			result.onChangeRules = append(result.onChangeRules, built)
 		}
-		result.quitSignal = d.quitSignalInt
+	}
+
+	// Fill in the settings the file and environment do not specify from
+	// the preset.
+	if preset != "" {
+		// Validated during flag parsing.
+		result.preset, _ = parsePreset(preset)
+	}
+	if err := applyPresetDefaults(&result, d, &preambleDependsOnSet); err != nil {
+		return nil, invalid(err)
+	}
 
-		// Now check command line arguments.
-		if serverCommand != "" {
-			result.serverCommand = serverCommand
+	// Now check command line arguments.
+	fromFlag := Origin{Source: SourceFlag}
+	if preset != "" {
+		result.origins["preset"] = fromFlag
+	}
+	if serverCommand != "" {
+		result.serverCommand = serverCommand
+		result.origins["server_command"] = fromFlag
+	}
+	if execCommand != "" {
+		result.execCommand = execCommand
+		result.origins["exec_command"] = fromFlag
+	}
+	if len(preambleCommands) > 0 {
+		result.preambles = preamblesFromCommands(preambleCommands)
+		preambleDependsOnSet = make([]bool, len(result.preambles))
+		result.origins["preamble_commands"] = fromFlag
+	}
+	if r, ok := flagRegexes(
+		result.includeFileRegexes,
+		includeFileRegexes,
+		addIncludeFileRegexes,
+		clearIncludeFileRegexes,
+	); ok {
+		result.includeFileRegexes = r
+		result.origins["include_file_regexes"] = fromFlag
+	}
+	if r, ok := flagRegexes(
+		result.excludeFileRegexes,
+		excludeFileRegexes,
+		addExcludeFileRegexes,
+		clearExcludeFileRegexes,
+	); ok {
+		result.excludeFileRegexes = r
+		result.origins["exclude_file_regexes"] = fromFlag
+	}
+	if logLevel != logger.NOTSET {
+		result.logLevel = logLevel
+		result.origins["log_level"] = fromFlag
+	}
+	if mode != "" {
+		// Validated during flag parsing.
+		result.mode, _ = parseMode(mode)
+		result.origins["mode"] = fromFlag
+	}
+	if watcher != "" {
+		// Validated during flag parsing.
+		result.watcher, _ = parseWatcherBackend(watcher)
+		result.origins["watcher"] = fromFlag
+	}
+
+	switch result.mode {
+	case ServerMode:
+		if result.serverCommand == "" {
+			return nil, invalid(errors.New("server command required"))
 		}
-		if len(preambleCommands) > 0 {
-			result.preambleCommands = preambleCommands
+	case ExecMode:
+		if result.execCommand == "" {
+			return nil, invalid(errors.New("exec command required in exec mode"))
 		}
-		if len(includeFileRegexes) > 0 {
-			result.includeFileRegexes = includeFileRegexes
+	}
+	if err := resolvePreambles(result.preambles, preambleDependsOnSet); err != nil {
+		if s := result.origin("preamble_commands").Source; s == SourceFile || s == SourceEnv {
+			return nil, d.errorAt("preamble_commands", err)
 		}
-		if len(excludeFileRegexes) > 0 {
-			result.excludeFileRegexes = excludeFileRegexes
+		return nil, invalid(err)
+	}
+	commands := append(
+		[]string{result.readinessCommand, result.execCommand},
+		result.PreambleCommands()...,
+	)
+	for _, r := range result.onChangeRules {
+		commands = append(commands, r.Command)
+	}
+	for _, c := range commands {
+		if _, err := template.New("command").Parse(c); err != nil {
+			return nil, invalid(fmt.Errorf("parsing command template %q: %w", c, err))
+		}
+	}
+	result.roots = watchRoots(result.workingDirectory, result.watchPaths)
+
+	return &result, nil
+}
+
+// watchRoots returns the absolute paths of the working directory wd and
+// of the directories among watchPaths, which are resolved against wd.
+// Watch paths that cannot be read are left out, since watching them
+// fails.
+func watchRoots(wd string, watchPaths []string) []string {
+	abs, err := filepath.Abs(wd)
+	if err != nil {
+		return nil
+	}
+	result := []string{abs}
+	for _, p := range watchPaths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(abs, p)
 		}
-		if logLevel != logger.NOTSET {
-			result.logLevel = logLevel
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			result = append(result, filepath.Clean(p))
 		}
 	}
+	return result
+}
 
-	if result.serverCommand == "" {
-		return nil, fmt.Errorf("server command required")
+// flagRegexes returns the regexes of a setting given the regexes current
+// from the config file, environment or preset, and those of its command
+// line flags. Regexes given with replace replace current, as does clear,
+// and regexes given with add are appended. It reports whether any of the
+// flags was given.
+func flagRegexes(current, replace, add []regexp.Regexp, clear bool) ([]regexp.Regexp, bool) {
+	if len(replace) == 0 && len(add) == 0 && !clear {
+		return current, false
+	}
+	result := current
+	if clear || len(replace) > 0 {
+		result = replace
 	}
+	return append(slices.Clone(result), add...), true
+}
 
-	return &result, nil
+// applyPresetDefaults sets the settings of the preset of result that are
+// not defined in the config file or environment d.
+func applyPresetDefaults(result *config, d *tomlConfig, preambleDependsOnSet *[]bool) error {
+	if result.preset == NoPreset {
+		return nil
+	}
+	defaults := result.preset.defaults()
+	// useDefault reports whether the preset supplies the setting key,
+	// recording the preset as its origin when it does.
+	useDefault := func(key string) bool {
+		if _, ok := d.origin(key); ok {
+			return false
+		}
+		result.origins[key] = Origin{Source: SourcePreset}
+		return true
+	}
+	var err error
+	if useDefault("include_file_regexes") {
+		if result.includeFileRegexes, err = compileRegexes(defaults.includeFileRegexes); err != nil {
+			return fmt.Errorf("parsing preset include file expressions: %w", err)
+		}
+	}
+	if useDefault("exclude_file_regexes") {
+		if result.excludeFileRegexes, err = compileRegexes(defaults.excludeFileRegexes); err != nil {
+			return fmt.Errorf("parsing preset exclude file expressions: %w", err)
+		}
+	}
+	if useDefault("ignore_directories") {
+		result.ignoreDirectories = defaults.ignoreDirectories
+	}
+	if useDefault("preamble_commands") {
+		result.preambles = nil
+		*preambleDependsOnSet = nil
+		for _, p := range defaults.preambleCommands {
+			if built, err := p.build(); err != nil {
+				return err
+			} else {
+				result.preambles = append(result.preambles, built)
+				*preambleDependsOnSet = append(*preambleDependsOnSet, p.dependsOnSet)
+			}
+		}
+	}
+	if useDefault("quit_signal") {
+		if result.quitSignal, err = parseQuitSignal(defaults.quitSignal); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func readConfigFile(wd string) (*tomlConfig, error) {
-	for _, filename := range configFileNames() {
-		joined := filepath.Join(wd, filename)
-		var d tomlConfig
-		if _, err := toml.DecodeFile(joined, &d); err != nil {
-			if errors.Is(err, os.ErrNotExist) {
+func preamblesFromCommands(commands []string) []Preamble {
+	result := make([]Preamble, 0, len(commands))
+	for _, c := range commands {
+		result = append(result, Preamble{Command: c})
+	}
+	return result
+}
+
+// readConfigFile decodes the config file at path or, when path is empty,
+// the config file in the directory wd. With searchParents, the nearest
+// parent directory of wd having a config file is used when wd has none.
+func readConfigFile(wd, path string, searchParents bool, profiles []string) (*tomlConfig, error) {
+	if path != "" {
+		return decodeConfigFile(path, profiles)
+	}
+	dir := wd
+	if searchParents {
+		var err error
+		if dir, err = filepath.Abs(wd); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		var found []string
+		for _, filename := range ConfigFileNames() {
+			joined := filepath.Join(dir, filename)
+			if _, err := os.Stat(joined); err == nil {
+				found = append(found, joined)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		switch len(found) {
+		case 0:
+			if parent := filepath.Dir(dir); searchParents && parent != dir {
+				dir = parent
 				continue
 			}
-			return nil, err
+			return nil, errConfigFileNotFound
+		case 1:
+			return decodeConfigFile(found[0], profiles)
+		default:
+			return nil, invalid(fmt.Errorf(
+				"found more than one config file, keep only one of: %s",
+				strings.Join(found, ", "),
+			))
+		}
+	}
+}
+
+// configFileExtensions returns the extensions of the supported config
+// file formats.
+func configFileExtensions() []string {
+	return []string{".toml", ".yaml", ".yml", ".json"}
+}
+
+// decodeConfigFile decodes the TOML, YAML or JSON config file at path,
+// merges the files it extends and applies the named profiles in order.
+func decodeConfigFile(path string, profiles []string) (*tomlConfig, error) {
+	d, err := loadConfigFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.merge(profiles); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// loadConfigFile decodes the config file at path and loads the files it
+// extends. extendedBy lists the absolute paths of the files extending
+// it, in order.
+func loadConfigFile(path string, extendedBy []string) (*tomlConfig, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := tomlConfig{path: path, absPath: absPath}
+	d.doc = string(content)
+	if filepath.Ext(path) == ".toml" {
+		d.lines = tomlKeyLines(content)
+	} else if d.doc, d.lines, err = yamlToTOML(path, content); err != nil {
+		return nil, err
+	} else {
+		d.converted = true
+	}
+	if md, err := toml.Decode(d.doc, &d); err != nil {
+		return nil, d.decodeError(err)
+	} else {
+		d.meta = md
+	}
+	if err := d.checkProfiles(); err != nil {
+		return nil, err
+	}
+	if err := d.checkUndecoded(); err != nil {
+		return nil, err
+	}
+	if err := d.checkMergeStrategy(); err != nil {
+		return nil, err
+	}
+	if err := d.loadBases(append(slices.Clone(extendedBy), absPath)); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// parseValues parses the settings of d that are not used as decoded.
+func (d *tomlConfig) parseValues() error {
+	var err error
+	if d.quitSignalInt, err = parseQuitSignal(d.QuitSignal); err != nil {
+		return d.errorAt("quit_signal", err)
+	}
+	if d.RestartStrategy != "" {
+		if r, err := parseRestartStrategy(d.RestartStrategy); err != nil {
+			return d.errorAt("restart_strategy", err)
+		} else {
+			d.restartStrategy = r
+		}
+	}
+	d.readinessTimeout = defaultReadinessTimeout
+	if d.ReadinessTimeout != "" {
+		if t, err := time.ParseDuration(d.ReadinessTimeout); err != nil {
+			return d.errorAt("readiness_timeout", fmt.Errorf("parsing readiness_timeout: %w", err))
+		} else {
+			d.readinessTimeout = t
+		}
+	}
+	if d.Watcher != "" {
+		if w, err := parseWatcherBackend(d.Watcher); err != nil {
+			return d.errorAt("watcher", err)
+		} else {
+			d.watcher = w
+		}
+	}
+	d.pollInterval = defaultPollInterval
+	if d.PollInterval != "" {
+		if t, err := time.ParseDuration(d.PollInterval); err != nil {
+			return d.errorAt("poll_interval", fmt.Errorf("parsing poll_interval: %w", err))
+		} else if t <= 0 {
+			return d.errorAt("poll_interval", fmt.Errorf("poll_interval must be positive: %s", d.PollInterval))
 		} else {
-			switch d.QuitSignal {
-			case "":
-				d.quitSignalInt = syscall.SIGINT
-			case "SIGINT":
-				d.quitSignalInt = syscall.SIGINT
-			case "SIGTERM":
-				d.quitSignalInt = syscall.SIGTERM
-			default:
-				return nil, fmt.Errorf("quit_signal value not supported: %s", d.QuitSignal)
+			d.pollInterval = t
+		}
+	}
+	return nil
+}
+
+// decodeErrorPattern matches the errors of the TOML decoder about values
+// that do not fit the schema.
+var decodeErrorPattern = regexp.MustCompile(`^toml: (?:line (\d+) )?\(last key "([^"]*)"\): (.*)$`)
+
+// decodeError locates err, returned when decoding the config file, in
+// the file. Positions reported by the decoder are only used for TOML
+// files since other formats are decoded after conversion to TOML.
+func (d *tomlConfig) decodeError(err error) error {
+	var pe toml.ParseError
+	if errors.As(err, &pe) {
+		message := pe.Message
+		if message == "" {
+			// Errors of toml.Unmarshaler implementations.
+			message = strings.TrimPrefix(pe.Error(), fmt.Sprintf("toml: line %d: ", pe.Position.Line))
+			if m := decodeErrorPattern.FindStringSubmatch(pe.Error()); m != nil {
+				message = m[3]
 			}
-			return &d, nil
 		}
+		line := pe.Position.Line
+		if d.converted {
+			line = keyLine(d.lines, pe.LastKey)
+		}
+		return &ConfigError{Problems: []*Problem{
+			{File: d.path, Line: line, Err: errors.New(message)},
+		}}
+	} else if m := decodeErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		return d.errorAt(m[2], errors.New(m[3]))
+	}
+	return &ConfigError{Problems: []*Problem{{File: d.path, Err: err}}}
+}
+
+// errorAt returns err as a problem located at the definition of key in
+// the config files, or naming the environment variable defining key.
+func (d *tomlConfig) errorAt(key string, err error) error {
+	top, rest, _ := strings.Cut(key, ".")
+	if name, ok := d.envVars[top]; ok {
+		return &ConfigError{Problems: []*Problem{{Err: fmt.Errorf("%s: %w", name, err)}}}
+	}
+	f, path := d, key
+	if def, defPath, ok := d.definition(top); ok {
+		f, path = def, defPath
+		if rest != "" {
+			path += "." + rest
+		}
+	}
+	return &ConfigError{Problems: []*Problem{
+		{File: f.path, Line: keyLine(f.lines, path), Err: err},
+	}}
+}
+
+// checkUndecoded reports the keys of the config file that are not part
+// of the schema.
+func (d *tomlConfig) checkUndecoded() error {
+	var problems []*Problem
+	for _, k := range d.meta.Undecoded() {
+		// Profiles share the schema of the top level.
+		rel := k
+		if k[0] == "profile" && len(k) > 2 {
+			rel = k[2:]
+		}
+		// The keys of preamble tables are checked while decoding them.
+		if rel[0] == "preamble_commands" {
+			continue
+		}
+		key := strings.Join(k, ".")
+		problems = append(problems, &Problem{
+			File: d.path,
+			Line: keyLine(d.lines, key),
+			Err:  unknownKeyError(key, k[len(k)-1], tableKeys[strings.Join(rel[:len(rel)-1], ".")]),
+		})
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// tableKeys maps the tables of the config file schema to their keys.
+var tableKeys = map[string][]string{
+	"":          structKeys(tomlConfig{}),
+	"on_change": structKeys(onChangeSpec{}),
+}
+
+// fileKeys are the top-level keys of the config file schema that
+// describe the config file rather than settings.
+var fileKeys = []string{"extends", "merge_strategy", "profile"}
+
+// settingKeys returns the keys of the settings of the config file
+// schema, which are its top-level keys other than fileKeys.
+func settingKeys() []string {
+	return slices.DeleteFunc(structKeys(tomlConfig{}), func(key string) bool {
+		return slices.Contains(fileKeys, key)
+	})
+}
+
+// listSettingKeys returns the keys of the settings holding lists.
+func listSettingKeys() []string {
+	return slices.DeleteFunc(settingKeys(), func(key string) bool {
+		return settingFields[key].Type.Kind() != reflect.Slice
+	})
+}
+
+// settingFields maps the keys of the config file schema to the fields
+// of tomlConfig.
+var settingFields = func() map[string]reflect.StructField {
+	t := reflect.TypeOf(tomlConfig{})
+	result := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("toml"); key != "" {
+			result[key] = t.Field(i)
+		}
+	}
+	return result
+}()
+
+// setting returns the field of d holding the setting key.
+func (d *tomlConfig) setting(key string) reflect.Value {
+	return reflect.ValueOf(d).Elem().FieldByIndex(settingFields[key].Index)
+}
+
+// structKeys returns the TOML keys of the fields of the struct v.
+func structKeys(v any) []string {
+	t := reflect.TypeOf(v)
+	result := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("toml"); key != "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+func parseQuitSignal(s string) (syscall.Signal, error) {
+	switch s {
+	case "":
+		return syscall.SIGINT, nil
+	case "SIGINT":
+		return syscall.SIGINT, nil
+	case "SIGTERM":
+		return syscall.SIGTERM, nil
+	default:
+		return syscall.SIGINT, fmt.Errorf("quit_signal value not supported: %s", s)
 	}
-	return nil, errConfigFileNotFound
 }
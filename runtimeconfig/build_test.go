@@ -1,11 +1,13 @@
 package runtimeconfig_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/jakewan/go-procrotator/logger"
 	"github.com/jakewan/go-procrotator/runtimeconfig"
@@ -20,7 +22,12 @@ func TestBuild(t *testing.T) {
 		validateConfig  func(t *testing.T, c runtimeconfig.Config)
 		validateError   func(t *testing.T, err error)
 		changeToTempDir bool
-		tempDirSetup    func(d string)
+		// workingSubdirectory is the subdirectory of the temporary
+		// directory to change to when changeToTempDir is set.
+		workingSubdirectory string
+		tempDirSetup        func(d string)
+		// env holds environment variables set during the test.
+		env map[string]string
 	}
 	var defaultConfigFileContent = []byte(
 		`include_file_regexes = ["\\.foo$", "\\.bar$"]
@@ -131,6 +138,1086 @@ include_file_regexes = ["\\.foo$"]
 			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
 				assert.Equal(t, logger.INFO, c.LogLevel())
 				assert.Equal(t, syscall.SIGINT, c.QuitSignal())
+				assert.Equal(t, runtimeconfig.StopThenStart, c.RestartStrategy())
+				assert.Equal(t, 30*time.Second, c.ReadinessTimeout())
+			},
+		},
+		{
+			desc:            "start-then-stop restart strategy",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.foo$"]
+server_command = "./some-app"
+restart_strategy = "start-then-stop"
+readiness_command = "curl -sf http://localhost:8080/healthz"
+readiness_timeout = "5s"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.StartThenStop, c.RestartStrategy())
+				assert.Equal(t, "curl -sf http://localhost:8080/healthz", c.ReadinessCommand())
+				assert.Equal(t, 5*time.Second, c.ReadinessTimeout())
+			},
+		},
+		{
+			desc:            "invalid restart strategy",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.foo$"]
+server_command = "./some-app"
+restart_strategy = "sometimes"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "restart_strategy value not supported: sometimes")
+			},
+		},
+		{
+			desc:            "environment settings",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.foo$"]
+server_command = "./some-app"
+env_file = [".env", ".env.local"]
+clear_env = true
+
+[env]
+APP_ENV = "development"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, map[string]string{"APP_ENV": "development"}, c.Env())
+				assert.Equal(t, []string{".env", ".env.local"}, c.EnvFiles())
+				assert.True(t, c.ClearEnv())
+			},
+		},
+		{
+			desc:            "invalid preamble command template",
+			args:            []string{"-p", "golangci-lint run {{.ChangedFiles"},
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					defaultConfigFileContent,
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "parsing command template")
+			},
+		},
+		{
+			desc:            "invalid on_change command template",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `server_command = "./some-app"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "npm run build:css -- {{.ChangedFiles"
+`,
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "parsing command template")
+			},
+		},
+		{
+			desc:            "routed preamble commands",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$", "\\.proto$"]
+server_command = "./some-app"
+preamble_commands = [
+  { command = "buf generate", include_file_regexes = ["\\.proto$"] },
+  "go build .",
+]
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, []string{"buf generate", "go build ."}, c.PreambleCommands())
+				assert.Equal(
+					t,
+					[]runtimeconfig.Preamble{
+						{
+							Name:               "buf generate",
+							Command:            "buf generate",
+							IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.proto$`)},
+						},
+						{
+							Name:      "go build .",
+							Command:   "go build .",
+							DependsOn: []string{"buf generate"},
+						},
+					},
+					c.Preambles(),
+				)
+			},
+		},
+		{
+			desc:            "on_change rules",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "npm run build:css"
+restart = false
+
+[[on_change]]
+include_file_regexes = ["\\.sql$"]
+command = "make migrate"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(
+					t,
+					[]runtimeconfig.OnChangeRule{
+						{
+							Command:            "npm run build:css",
+							IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.css$`)},
+							Restart:            false,
+						},
+						{
+							Command:            "make migrate",
+							IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.sql$`)},
+							Restart:            true,
+						},
+					},
+					c.OnChangeRules(),
+				)
+			},
+		},
+		{
+			desc:            "preamble dependency graph",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+
+[[preamble_commands]]
+name = "protoc"
+command = "protoc --go_out=. api.proto"
+inputs = ["api/**/*.proto"]
+outputs = ["gen"]
+
+[[preamble_commands]]
+name = "generate"
+command = "go generate ./..."
+
+[[preamble_commands]]
+name = "build"
+command = "go build ."
+depends_on = ["generate"]
+
+[[preamble_commands]]
+name = "npm"
+command = "npm run build"
+depends_on = []
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				dependsOn := map[string][]string{}
+				for _, p := range c.Preambles() {
+					dependsOn[p.Name] = p.DependsOn
+				}
+				assert.Equal(t, []string{"api/**/*.proto"}, c.Preambles()[0].Inputs)
+				assert.Equal(t, []string{"gen"}, c.Preambles()[0].Outputs)
+				assert.Equal(
+					t,
+					map[string][]string{
+						"protoc":   nil,
+						"generate": {"protoc"},
+						"build":    {"generate"},
+						"npm":      {},
+					},
+					dependsOn,
+				)
+			},
+		},
+		{
+			desc:            "preamble dependency cycle",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+preamble_commands = [
+  { name = "a", command = "make a", depends_on = ["c"] },
+  { name = "b", command = "make b", depends_on = ["a"] },
+  { name = "c", command = "make c", depends_on = ["b"] },
+]
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "preamble dependency cycle: a -> c -> b -> a")
+			},
+		},
+		{
+			desc:            "preamble unknown dependency",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+preamble_commands = [{ name = "a", command = "make a", depends_on = ["z"] }]
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "preamble a depends on unknown preamble z")
+			},
+		},
+		{
+			desc:            "exec mode from config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+mode = "exec"
+exec_command = "go test ./..."
+preset = "go"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.ExecMode, c.Mode())
+				assert.Equal(t, "go test ./...", c.ExecCommand())
+				assert.Equal(t, "", c.ServerCommand())
+				assert.Equal(t, runtimeconfig.GoPreset, c.Preset())
+			},
+		},
+		{
+			desc:            "exec mode from command line",
+			args:            []string{"-mode", "exec", "-x", "go test ./..."},
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					defaultConfigFileContent,
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.ExecMode, c.Mode())
+				assert.Equal(t, "go test ./...", c.ExecCommand())
+			},
+		},
+		{
+			desc:            "exec mode requires exec command",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+mode = "exec"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "exec command required in exec mode")
+			},
+		},
+		{
+			desc:            "preset defaults",
+			args:            []string{"-preset", "rust"},
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`server_command = "./target/debug/some-app"`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.RustPreset, c.Preset())
+				assert.Equal(
+					t,
+					[]regexp.Regexp{
+						*regexp.MustCompile(`\.rs$`),
+						*regexp.MustCompile(`(^|/)Cargo\.(toml|lock)$`),
+					},
+					c.IncludeFileRegexes(),
+				)
+				assert.Equal(t, []string{".git", "target"}, c.IgnoreDirectories())
+				assert.Equal(t, []string{"cargo build"}, c.PreambleCommands())
+				assert.Equal(t, syscall.SIGTERM, c.QuitSignal())
+			},
+		},
+		{
+			desc:            "preset overridden field by field",
+			args:            []string{"-i", "\\.tmpl$"},
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+preset = "go"
+server_command = "./some-app"
+preamble_commands = []
+quit_signal = "SIGINT"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.GoPreset, c.Preset())
+				assert.Equal(
+					t,
+					[]regexp.Regexp{*regexp.MustCompile(`\.tmpl$`)},
+					c.IncludeFileRegexes(),
+				)
+				assert.Equal(t, []string{".git", "vendor", "testdata"}, c.IgnoreDirectories())
+				assert.Empty(t, c.PreambleCommands())
+				assert.Equal(t, syscall.SIGINT, c.QuitSignal())
+			},
+		},
+		{
+			desc:            "unknown preset",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.toml"),
+					[]byte(`
+preset = "cobol"
+server_command = "./some-app"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "preset value not supported")
+			},
+		},
+		{
+			desc:            "yaml config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, "procrotator.yaml"),
+					[]byte(`include_file_regexes:
+  - '\.go$'
+server_command: ./some-app
+quit_signal: SIGTERM
+preamble_commands:
+  - go generate ./...
+  - name: build
+    command: go build .
+env:
+  PORT: "8080"
+on_change:
+  - command: make assets
+    include_file_regexes: ['\.css$']
+    restart: false
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, syscall.SIGTERM, c.QuitSignal())
+				assert.Equal(t, []regexp.Regexp{*regexp.MustCompile(`\.go$`)}, c.IncludeFileRegexes())
+				assert.Equal(t, []string{"go generate ./...", "go build ."}, c.PreambleCommands())
+				assert.Equal(t, []string{"go generate ./..."}, c.Preambles()[1].DependsOn)
+				assert.Equal(t, map[string]string{"PORT": "8080"}, c.Env())
+				if assert.Len(t, c.OnChangeRules(), 1) {
+					assert.False(t, c.OnChangeRules()[0].Restart)
+				}
+			},
+		},
+		{
+			desc:            "json config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, ".procrotator.json"),
+					[]byte(`{
+  "include_file_regexes": ["\\.go$"],
+  "server_command": "./some-app",
+  "readiness_timeout": "5s"
+}`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, []regexp.Regexp{*regexp.MustCompile(`\.go$`)}, c.IncludeFileRegexes())
+				assert.Equal(t, 5*time.Second, c.ReadinessTimeout())
+			},
+		},
+		{
+			desc:            "more than one config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				for _, name := range []string{"procrotator.toml", "procrotator.yml"} {
+					if err := os.WriteFile(filepath.Join(d, name), []byte{}, 0666); err != nil {
+						panic(err)
+					}
+				}
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "found more than one config file")
+				assert.ErrorContains(t, err, "procrotator.toml")
+				assert.ErrorContains(t, err, "procrotator.yml")
+			},
+		},
+		{
+			desc:            "config file flag",
+			changeToTempDir: true,
+			argsFunc: func(d string) []string {
+				return []string{"-config", filepath.Join(d, "conf", "custom.yaml")}
+			},
+			tempDirSetup: func(d string) {
+				if err := os.Mkdir(filepath.Join(d, "conf"), 0777); err != nil {
+					panic(err)
+				}
+				if err := os.WriteFile(
+					filepath.Join(d, "conf", "custom.yaml"),
+					[]byte("server_command: ./some-app\n"),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+				// Ignored in favor of the flag.
+				if err := os.WriteFile(
+					filepath.Join(d, "procrotator.toml"),
+					[]byte(`server_command = "./other-app"`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, "conf", filepath.Base(c.WorkingDirectory()))
+			},
+		},
+		{
+			desc:                "search parent directories",
+			changeToTempDir:     true,
+			workingSubdirectory: filepath.Join("a", "b"),
+			args:                []string{"-searchparents"},
+			tempDirSetup:        writeConfigInParent,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, filepath.Dir(c.ConfigFile()), c.WorkingDirectory())
+				assert.DirExists(t, filepath.Join(c.WorkingDirectory(), "a", "b"))
+			},
+		},
+		{
+			desc:            "search parent directories of specified directory",
+			changeToTempDir: false,
+			argsFunc: func(d string) []string {
+				return []string{"-d", filepath.Join(d, "a", "b"), "-searchparents"}
+			},
+			tempDirSetup: writeConfigInParent,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, "b", filepath.Base(c.WorkingDirectory()))
+			},
+		},
+		{
+			desc:            "parent directories not searched by default",
+			changeToTempDir: false,
+			argsFunc: func(d string) []string {
+				return []string{"-d", filepath.Join(d, "a", "b")}
+			},
+			tempDirSetup: writeConfigInParent,
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "server command required")
+			},
+		},
+		{
+			desc:            "profiles applied in order",
+			changeToTempDir: true,
+			args:            []string{"-profile", "debug", "-profile", "verbose"},
+			tempDirSetup:    writeProfilesConfig,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, []string{"debug", "verbose"}, c.Profiles())
+				assert.Equal(t, "dlv exec ./some-app", c.ServerCommand())
+				assert.Equal(t, logger.NOTICE, c.LogLevel())
+				assert.Equal(t, map[string]string{"PORT": "8080", "DEBUG": "1"}, c.Env())
+				assert.Equal(t, []regexp.Regexp{*regexp.MustCompile(`\.go$`)}, c.IncludeFileRegexes())
+				assert.Contains(t, c.String(), "Profiles: [debug verbose]")
+			},
+		},
+		{
+			desc:            "profiles not applied by default",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Empty(t, c.Profiles())
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, logger.INFO, c.LogLevel())
+				assert.Equal(t, map[string]string{"PORT": "8080"}, c.Env())
+			},
+		},
+		{
+			desc:            "profile replaces lists",
+			changeToTempDir: true,
+			args:            []string{"-profile", "assets"},
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, "procrotator.toml"),
+					[]byte(`include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+preamble_commands = [
+  { name = "generate", command = "go generate ./...", include_file_regexes = ["\\.proto$"] },
+  "go build .",
+]
+
+[[on_change]]
+command = "make assets"
+include_file_regexes = ["\\.css$"]
+exclude_file_regexes = ["vendor/"]
+restart = false
+
+[[on_change]]
+command = "make migrate"
+include_file_regexes = ["\\.sql$"]
+
+[profile.assets]
+preamble_commands = ["npm run build"]
+
+[[profile.assets.on_change]]
+command = "npm run build:css"
+include_file_regexes = ["\\.scss$"]
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				// The elements of the replaced lists do not keep the fields
+				// the profile does not set.
+				assert.Equal(t, []runtimeconfig.Preamble{{Name: "npm run build", Command: "npm run build"}}, c.Preambles())
+				assert.Equal(t, []runtimeconfig.OnChangeRule{
+					{
+						Command:            "npm run build:css",
+						IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.scss$`)},
+						Restart:            true,
+					},
+				}, c.OnChangeRules())
+			},
+		},
+		{
+			desc:            "unknown profile",
+			changeToTempDir: true,
+			args:            []string{"-profile", "debgu"},
+			tempDirSetup:    writeProfilesConfig,
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "unknown profile debgu (did you mean debug?)")
+			},
+		},
+		{
+			desc:            "profile without config file",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app", "-profile", "debug"},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "profile debug requested but no config file found")
+			},
+		},
+		{
+			desc:            "settings from environment without config file",
+			changeToTempDir: true,
+			env: map[string]string{
+				"PROCROTATOR_SERVER_COMMAND":       "./some-app --port 8080",
+				"PROCROTATOR_INCLUDE_FILE_REGEXES": `["\\.go$", "\\.tmpl$"]`,
+				"PROCROTATOR_EXCLUDE_FILE_REGEXES": `_test\.go$`,
+				"PROCROTATOR_PREAMBLE_COMMANDS":    "go build .",
+				"PROCROTATOR_CLEAR_ENV":            "true",
+				"PROCROTATOR_ENV":                  `{ PORT = "8080" }`,
+				"PROCROTATOR_READINESS_TIMEOUT":    "5s",
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app --port 8080", c.ServerCommand())
+				assert.Equal(
+					t,
+					[]regexp.Regexp{
+						*regexp.MustCompile(`\.go$`),
+						*regexp.MustCompile(`\.tmpl$`),
+					},
+					c.IncludeFileRegexes(),
+				)
+				assert.Equal(t, []regexp.Regexp{*regexp.MustCompile(`_test\.go$`)}, c.ExcludeFileRegexes())
+				assert.Equal(t, []string{"go build ."}, c.PreambleCommands())
+				assert.True(t, c.ClearEnv())
+				assert.Equal(t, map[string]string{"PORT": "8080"}, c.Env())
+				assert.Equal(t, 5*time.Second, c.ReadinessTimeout())
+				assert.Empty(t, c.ConfigFile())
+			},
+		},
+		{
+			desc:            "environment merges env table of config file",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env:             map[string]string{"PROCROTATOR_ENV": `{ DEBUG = "1" }`},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, map[string]string{"PORT": "8080", "DEBUG": "1"}, c.Env())
+			},
+		},
+		{
+			desc:            "environment overrides profile",
+			changeToTempDir: true,
+			args:            []string{"-profile", "debug"},
+			tempDirSetup:    writeProfilesConfig,
+			env:             map[string]string{"PROCROTATOR_LOG_LEVEL": "ERROR"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "dlv exec ./some-app", c.ServerCommand())
+				assert.Equal(t, logger.ERROR, c.LogLevel())
+			},
+		},
+		{
+			desc:            "environment overrides preset",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app", "-preset", "go"},
+			env:             map[string]string{"PROCROTATOR_PREAMBLE_COMMANDS": "[]"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Empty(t, c.PreambleCommands())
+			},
+		},
+		{
+			desc:            "empty environment variable ignored",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env:             map[string]string{"PROCROTATOR_SERVER_COMMAND": ""},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+			},
+		},
+		{
+			desc:            "invalid value in environment",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env:             map[string]string{"PROCROTATOR_QUIT_SIGNAL": "SIGHUP"},
+			validateError: func(t *testing.T, err error) {
+				var ce *runtimeconfig.ConfigError
+				assert.ErrorAs(t, err, &ce)
+				assert.EqualError(t, err, "PROCROTATOR_QUIT_SIGNAL: quit_signal value not supported: SIGHUP")
+			},
+		},
+		{
+			desc:            "invalid syntax in environment",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env: map[string]string{
+				"PROCROTATOR_CLEAR_ENV": "yes",
+				"PROCROTATOR_ENV":       `{ PORT = 8080 }`,
+			},
+			validateError: func(t *testing.T, err error) {
+				var ce *runtimeconfig.ConfigError
+				if assert.ErrorAs(t, err, &ce) {
+					assert.Len(t, ce.Problems, 2)
+				}
+				assert.ErrorContains(t, err, "PROCROTATOR_CLEAR_ENV: ")
+				assert.ErrorContains(t, err, "PROCROTATOR_ENV: ")
+			},
+		},
+		{
+			desc:            "environment replaces lists",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, "procrotator.toml"),
+					[]byte(`include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+
+[[on_change]]
+command = "make assets"
+include_file_regexes = ["\\.css$"]
+exclude_file_regexes = ["vendor/"]
+restart = false
+
+[[on_change]]
+command = "make migrate"
+include_file_regexes = ["\\.sql$"]
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			env: map[string]string{
+				"PROCROTATOR_ON_CHANGE": `[{ command = "npm run build:css", include_file_regexes = ["\\.scss$"] }]`,
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				// The elements of the replaced list do not keep the fields
+				// the variable does not set.
+				assert.Equal(t, []runtimeconfig.OnChangeRule{
+					{
+						Command:            "npm run build:css",
+						IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.scss$`)},
+						Restart:            true,
+					},
+				}, c.OnChangeRules())
+			},
+		},
+		{
+			desc:            "control characters in environment",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env: map[string]string{
+				"PROCROTATOR_SERVER_COMMAND":    "./some-app --name \"caf\u00e9\a\"",
+				"PROCROTATOR_PREAMBLE_COMMANDS": "printf '\x7f\\n'",
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app --name \"caf\u00e9\a\"", c.ServerCommand())
+				assert.Equal(t, []string{"printf '\x7f\\n'"}, c.PreambleCommands())
+			},
+		},
+		{
+			desc:            "invalid UTF-8 in environment",
+			changeToTempDir: true,
+			tempDirSetup:    writeProfilesConfig,
+			env:             map[string]string{"PROCROTATOR_SERVER_COMMAND": "./some-app \xff"},
+			validateError: func(t *testing.T, err error) {
+				var ce *runtimeconfig.ConfigError
+				assert.ErrorAs(t, err, &ce)
+				assert.EqualError(t, err, `PROCROTATOR_SERVER_COMMAND: string is not valid UTF-8: "./some-app \xff"`)
+			},
+		},
+		{
+			desc:            "control characters in yaml config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				if err := os.WriteFile(
+					filepath.Join(d, "procrotator.yaml"),
+					[]byte(`include_file_regexes: ['\.go$']
+server_command: "./some-app\a\x7f"
+`),
+					0666,
+				); err != nil {
+					panic(err)
+				}
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app\a\x7f", c.ServerCommand())
+			},
+		},
+		{
+			desc:                "extended config files",
+			changeToTempDir:     true,
+			workingSubdirectory: "app",
+			tempDirSetup:        writeExtendsConfig,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, "./some-app", c.ServerCommand())
+				assert.Equal(t, syscall.SIGTERM, c.QuitSignal())
+				assert.Equal(t, []string{"node_modules"}, c.IgnoreDirectories())
+				// Lists are replaced by default.
+				assert.Equal(t, []regexp.Regexp{*regexp.MustCompile(`\.tmpl$`)}, c.IncludeFileRegexes())
+				// The merge strategy appends exclude_file_regexes.
+				assert.Equal(
+					t,
+					[]regexp.Regexp{
+						*regexp.MustCompile(`_test\.go$`),
+						*regexp.MustCompile(`^vendor/`),
+					},
+					c.ExcludeFileRegexes(),
+				)
+				assert.Equal(t, map[string]string{"PORT": "8080", "LOG": "debug"}, c.Env())
+				// Replacing a list of tables leaves no fields of the
+				// inherited tables behind.
+				if assert.Len(t, c.OnChangeRules(), 1) {
+					assert.Equal(t, "sass", c.OnChangeRules()[0].Command)
+					assert.True(t, c.OnChangeRules()[0].Restart)
+				}
+				wd, _ := os.Getwd()
+				shared := filepath.Join(filepath.Dir(wd), "shared")
+				assert.Equal(
+					t,
+					[]string{
+						filepath.Join(shared, "common.toml"),
+						filepath.Join(shared, "procrotator.base.toml"),
+					},
+					c.BaseConfigFiles(),
+				)
+				origins := map[string]runtimeconfig.Origin{}
+				for _, s := range c.Settings() {
+					origins[s.Key] = s.Origin
+				}
+				assert.Equal(
+					t,
+					runtimeconfig.Origin{
+						Source: runtimeconfig.SourceFile,
+						File:   filepath.Join("..", "shared", "procrotator.base.toml"),
+						Line:   4,
+					},
+					origins["quit_signal"],
+				)
+				assert.Equal(t, "procrotator.toml", origins["exclude_file_regexes"].File)
+			},
+		},
+		{
+			desc:                "profile of extended config file",
+			changeToTempDir:     true,
+			workingSubdirectory: "app",
+			args:                []string{"-profile", "debug"},
+			tempDirSetup:        writeExtendsConfig,
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, logger.DEBUG, c.LogLevel())
+				assert.Equal(t, "./some-app", c.ServerCommand())
+			},
+		},
+		{
+			desc:            "extended config files extend each other",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `extends = ["a.toml"]`,
+					"a.toml":           `extends = ["b.toml"]`,
+					"b.toml":           `extends = ["a.toml"]`,
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				var ce *runtimeconfig.ConfigError
+				assert.ErrorAs(t, err, &ce)
+				assert.Regexp(t, `^b\.toml:1: config files extend each other: \S+/a\.toml -> \S+/b\.toml -> \S+/a\.toml$`, err.Error())
+			},
+		},
+		{
+			desc:            "extended config file missing",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": "server_command = \"./some-app\"\nextends = [\"missing.toml\"]",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				var ce *runtimeconfig.ConfigError
+				assert.ErrorAs(t, err, &ce)
+				assert.ErrorContains(t, err, "procrotator.toml:2: reading extended config file: open missing.toml: ")
+			},
+		},
+		{
+			desc:            "unknown key in extended config file",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `extends = ["base.toml"]`,
+					"base.toml":        "\nserver_comand = \"./some-app\"",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "base.toml:2: unknown key server_comand (did you mean server_command?)")
+			},
+		},
+		{
+			desc:            "merge strategy of setting that is not a list",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": "server_command = \"./some-app\"\n[merge_strategy]\nserver_command = \"append\"",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.ErrorContains(t, err, "procrotator.toml:3: unknown key merge_strategy.server_command")
+			},
+		},
+		{
+			desc:            "unsupported merge strategy",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": "server_command = \"./some-app\"\nmerge_strategy = { env_file = \"prepend\" }",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "procrotator.toml:2: merge_strategy value not supported: prepend")
+			},
+		},
+		{
+			desc:            "polling watcher",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `server_command = "./some-app"
+watcher = "poll"
+poll_interval = "250ms"
+poll_hash = true
+poll_over_watch_limit = true
+`,
+				})
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.PollWatcher, c.Watcher())
+				assert.Equal(t, 250*time.Millisecond, c.PollInterval())
+				assert.True(t, c.PollHash())
+				assert.True(t, c.PollOverWatchLimit())
+			},
+		},
+		{
+			desc:            "watcher defaults",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.AutoWatcher, c.Watcher())
+				assert.Equal(t, time.Second, c.PollInterval())
+				assert.False(t, c.PollHash())
+				assert.False(t, c.PollOverWatchLimit())
+			},
+		},
+		{
+			desc:            "watcher flag",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app", "-watcher", "fsnotify"},
+			env:             map[string]string{"PROCROTATOR_WATCHER": "poll"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.FSNotifyWatcher, c.Watcher())
+			},
+		},
+		{
+			desc:            "fanotify watcher",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app"},
+			env:             map[string]string{"PROCROTATOR_WATCHER": "fanotify"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, runtimeconfig.FanotifyWatcher, c.Watcher())
+			},
+		},
+		{
+			desc:            "watch paths",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `server_command = "./some-app"
+watch_paths = ["../shared", "/etc/ourapp/app.yaml"]
+`,
+				})
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, []string{"../shared", "/etc/ourapp/app.yaml"}, c.WatchPaths())
+			},
+		},
+		{
+			desc:            "watch path roots",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": `server_command = "./some-app"
+watch_paths = ["lib", "notes.txt", "missing"]
+`,
+					"lib/lib.go": "package lib",
+					"notes.txt":  "",
+				})
+			},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				// Only the directories among the watch paths are roots.
+				wd, _ := os.Getwd()
+				assert.Equal(t, []string{wd, filepath.Join(wd, "lib")}, c.Roots())
+			},
+		},
+		{
+			desc:            "watch path from environment",
+			changeToTempDir: true,
+			args:            []string{"-s", "./some-app"},
+			env:             map[string]string{"PROCROTATOR_WATCH_PATHS": "../shared"},
+			validateConfig: func(t *testing.T, c runtimeconfig.Config) {
+				assert.Equal(t, []string{"../shared"}, c.WatchPaths())
+			},
+		},
+		{
+			desc:            "empty watch path",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": "server_command = \"./some-app\"\nwatch_paths = [\"\"]",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "procrotator.toml:2: watch_paths must not contain empty paths")
+			},
+		},
+		{
+			desc:            "invalid poll interval",
+			changeToTempDir: true,
+			tempDirSetup: func(d string) {
+				writeFiles(d, map[string]string{
+					"procrotator.toml": "server_command = \"./some-app\"\npoll_interval = \"0s\"",
+				})
+			},
+			validateError: func(t *testing.T, err error) {
+				assert.EqualError(t, err, "procrotator.toml:2: poll_interval must be positive: 0s")
 			},
 		},
 		{
@@ -165,8 +1252,11 @@ include_file_regexes = ["\\.foo$"]
 				if cfg.tempDirSetup != nil {
 					cfg.tempDirSetup(tempDir)
 				}
+				for k, v := range cfg.env {
+					t.Setenv(k, v)
+				}
 				if cfg.changeToTempDir {
-					if err := os.Chdir(tempDir); err != nil {
+					if err := os.Chdir(filepath.Join(tempDir, cfg.workingSubdirectory)); err != nil {
 						assert.FailNow(t, "Error setting working directory: %w", err)
 					}
 				}
@@ -195,3 +1285,298 @@ include_file_regexes = ["\\.foo$"]
 		)
 	}
 }
+
+func TestBuildPrecedence(t *testing.T) {
+	// Each layer sets the same settings to its own values. The setting
+	// is expected from the highest layer present.
+	type layer struct {
+		source        runtimeconfig.Source
+		serverCommand string
+		logLevel      string
+		includeRegex  string
+	}
+	fileLayer := layer{runtimeconfig.SourceFile, "./from-file", "DEBUG", `\.file$`}
+	envLayer := layer{runtimeconfig.SourceEnv, "./from-env", "NOTICE", `\.env$`}
+	flagLayer := layer{runtimeconfig.SourceFlag, "./from-flag", "ERROR", `\.flag$`}
+	for _, withFile := range []bool{false, true} {
+		for _, withEnv := range []bool{false, true} {
+			for _, withFlag := range []bool{false, true} {
+				t.Run(
+					fmt.Sprintf("file=%t env=%t flag=%t", withFile, withEnv, withFlag),
+					func(t *testing.T) {
+						// Setup
+						tempDir := t.TempDir()
+						// The server command is required, so it defaults to
+						// one from the command line.
+						expected := layer{runtimeconfig.SourceDefault, "./default", "INFO", ""}
+						args := []string{"-d", tempDir, "-s", expected.serverCommand}
+						if withFile {
+							if err := os.WriteFile(
+								filepath.Join(tempDir, "procrotator.toml"),
+								[]byte(fmt.Sprintf(
+									"server_command = %q\nlog_level = %q\ninclude_file_regexes = [%q]\n",
+									fileLayer.serverCommand,
+									fileLayer.logLevel,
+									fileLayer.includeRegex,
+								)),
+								0666,
+							); err != nil {
+								assert.FailNow(t, "Error writing config file", err)
+							}
+							expected = fileLayer
+							args = []string{"-d", tempDir}
+						}
+						if withEnv {
+							t.Setenv("PROCROTATOR_SERVER_COMMAND", envLayer.serverCommand)
+							t.Setenv("PROCROTATOR_LOG_LEVEL", envLayer.logLevel)
+							t.Setenv("PROCROTATOR_INCLUDE_FILE_REGEXES", envLayer.includeRegex)
+							expected = envLayer
+							args = []string{"-d", tempDir}
+						}
+						if withFlag {
+							expected = flagLayer
+							args = []string{
+								"-d", tempDir,
+								"-s", flagLayer.serverCommand,
+								"-l", flagLayer.logLevel,
+								"-i", flagLayer.includeRegex,
+							}
+						}
+
+						// Code under test
+						c, err := runtimeconfig.Build(args)
+						if !assert.NoError(t, err) {
+							return
+						}
+
+						// Server command defaults come from the flag above.
+						serverCommandSource := expected.source
+						if serverCommandSource == runtimeconfig.SourceDefault {
+							serverCommandSource = runtimeconfig.SourceFlag
+						}
+						assert.Equal(t, expected.serverCommand, c.ServerCommand())
+						assert.Equal(t, expected.logLevel, c.LogLevel().String())
+						if expected.includeRegex == "" {
+							assert.Empty(t, c.IncludeFileRegexes())
+						} else {
+							assert.Equal(
+								t,
+								[]regexp.Regexp{*regexp.MustCompile(expected.includeRegex)},
+								c.IncludeFileRegexes(),
+							)
+						}
+						sources := map[string]runtimeconfig.Source{}
+						for _, s := range c.Settings() {
+							sources[s.Key] = s.Origin.Source
+						}
+						assert.Equal(t, serverCommandSource, sources["server_command"])
+						assert.Equal(t, expected.source, sources["log_level"])
+						assert.Equal(t, expected.source, sources["include_file_regexes"])
+					},
+				)
+			}
+		}
+	}
+}
+
+func TestBuildRegexFlags(t *testing.T) {
+	// The config file includes \.foo$ and \.bar$ and excludes
+	// ignore\.foo$.
+	type testConfig struct {
+		desc            string
+		args            []string
+		expectedInclude []string
+		expectedExclude []string
+		expectedSource  runtimeconfig.Source
+	}
+	testConfigs := []testConfig{
+		{
+			desc:            "config file only",
+			expectedInclude: []string{`\.foo$`, `\.bar$`},
+			expectedExclude: []string{`ignore\.foo$`},
+			expectedSource:  runtimeconfig.SourceFile,
+		},
+		{
+			desc:            "replace",
+			args:            []string{"-i", `\.baz$`, "-e", `ignore\.baz$`},
+			expectedInclude: []string{`\.baz$`},
+			expectedExclude: []string{`ignore\.baz$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "add",
+			args:            []string{"-i+", `\.baz$`, "-e+", `ignore\.baz$`, "-e+", `ignore\.quux$`},
+			expectedInclude: []string{`\.foo$`, `\.bar$`, `\.baz$`},
+			expectedExclude: []string{`ignore\.foo$`, `ignore\.baz$`, `ignore\.quux$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "add with long names",
+			args:            []string{"-addincludefileregexes", `\.baz$`, "--addexcludefileregexes", `ignore\.baz$`},
+			expectedInclude: []string{`\.foo$`, `\.bar$`, `\.baz$`},
+			expectedExclude: []string{`ignore\.foo$`, `ignore\.baz$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "replace and add",
+			args:            []string{"-i+", `\.quux$`, "-i", `\.baz$`, "-e", `ignore\.baz$`, "-e+", `ignore\.quux$`},
+			expectedInclude: []string{`\.baz$`, `\.quux$`},
+			expectedExclude: []string{`ignore\.baz$`, `ignore\.quux$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "clear",
+			args:            []string{"-i", `\.baz$`, "-clearexcludefileregexes"},
+			expectedInclude: []string{`\.baz$`},
+			expectedExclude: []string{},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "clear and add",
+			args:            []string{"-clearincludefileregexes", "-i+", `\.baz$`, "-clearexcludefileregexes", "-e+", `ignore\.baz$`},
+			expectedInclude: []string{`\.baz$`},
+			expectedExclude: []string{`ignore\.baz$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+		{
+			desc:            "clear and replace",
+			args:            []string{"-clearincludefileregexes", "-i", `\.baz$`, "-clearexcludefileregexes", "-e", `ignore\.baz$`},
+			expectedInclude: []string{`\.baz$`},
+			expectedExclude: []string{`ignore\.baz$`},
+			expectedSource:  runtimeconfig.SourceFlag,
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(
+			cfg.desc,
+			func(t *testing.T) {
+				// Setup
+				tempDir := t.TempDir()
+				writeFiles(tempDir, map[string]string{
+					"procrotator.toml": `include_file_regexes = ["\\.foo$", "\\.bar$"]
+exclude_file_regexes = ["ignore\\.foo$"]
+server_command = "./some-app"
+`,
+				})
+
+				// Code under test
+				c, err := runtimeconfig.Build(append([]string{"-d", tempDir}, cfg.args...))
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				regexStrings := func(regexes []regexp.Regexp) []string {
+					result := []string{}
+					for _, r := range regexes {
+						result = append(result, r.String())
+					}
+					return result
+				}
+				assert.Equal(t, cfg.expectedInclude, regexStrings(c.IncludeFileRegexes()))
+				assert.Equal(t, cfg.expectedExclude, regexStrings(c.ExcludeFileRegexes()))
+				for _, s := range c.Settings() {
+					if s.Key == "include_file_regexes" || s.Key == "exclude_file_regexes" {
+						assert.Equal(t, cfg.expectedSource, s.Origin.Source, s.Key)
+					}
+				}
+			},
+		)
+	}
+}
+
+// writeConfigInParent writes a config file to d and creates the directory
+// a/b below it.
+func writeConfigInParent(d string) {
+	if err := os.MkdirAll(filepath.Join(d, "a", "b"), 0777); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(
+		filepath.Join(d, "procrotator.toml"),
+		[]byte(`server_command = "./some-app"`),
+		0666,
+	); err != nil {
+		panic(err)
+	}
+}
+
+// writeProfilesConfig writes a config file with profiles to d.
+func writeProfilesConfig(d string) {
+	if err := os.WriteFile(
+		filepath.Join(d, "procrotator.toml"),
+		[]byte(`include_file_regexes = ["\\.go$"]
+server_command = "./some-app"
+
+[env]
+PORT = "8080"
+
+[profile.debug]
+server_command = "dlv exec ./some-app"
+log_level = "DEBUG"
+
+[profile.debug.env]
+DEBUG = "1"
+
+[profile.verbose]
+log_level = "NOTICE"
+`),
+		0666,
+	); err != nil {
+		panic(err)
+	}
+}
+
+// writeExtendsConfig writes a config file to the directory app of d that
+// extends a config file in the directory shared, which extends another.
+func writeExtendsConfig(d string) {
+	writeFiles(d, map[string]string{
+		"shared/common.toml": `ignore_directories = ["node_modules"]
+server_command = "./common-app"
+`,
+		"shared/procrotator.base.toml": `extends = ["common.toml"]
+include_file_regexes = ["\\.go$"]
+exclude_file_regexes = ["_test\\.go$"]
+quit_signal = "SIGTERM"
+
+[env]
+PORT = "8080"
+LOG = "info"
+
+[[on_change]]
+include_file_regexes = ["\\.css$"]
+command = "npm run css"
+restart = false
+
+[profile.debug]
+log_level = "DEBUG"
+`,
+		"app/procrotator.toml": `extends = ["../shared/procrotator.base.toml"]
+server_command = "./some-app"
+include_file_regexes = ["\\.tmpl$"]
+exclude_file_regexes = ["^vendor/"]
+
+[merge_strategy]
+exclude_file_regexes = "append"
+
+[env]
+LOG = "debug"
+
+[[on_change]]
+include_file_regexes = ["\\.scss$"]
+command = "sass"
+`,
+	})
+}
+
+// writeFiles writes files, mapping paths relative to d to their content,
+// creating directories as needed.
+func writeFiles(d string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(d, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			panic(err)
+		}
+	}
+}
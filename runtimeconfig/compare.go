@@ -0,0 +1,76 @@
+package runtimeconfig
+
+import (
+	"maps"
+	"regexp"
+	"slices"
+)
+
+// Changes describes the differences between two configurations that
+// matter when applying a new configuration while go-procrotator runs.
+type Changes struct {
+	// Mode reports a change of mode, which cannot be applied without
+	// restarting go-procrotator.
+	Mode bool
+	// Watcher reports a change of the watcher backend or its settings,
+	// which cannot be applied without restarting go-procrotator.
+	Watcher bool
+	// LogLevel reports a change of log level.
+	LogLevel bool
+	// WatchFilters reports changes to the include and exclude regexes,
+	// the env files or the patterns of the on_change rules, which
+	// determine the files whose changes are reported.
+	WatchFilters bool
+	// IgnoreDirectories reports a change of the directories that are not
+	// watched, which requires walking the working directory again.
+	IgnoreDirectories bool
+	// WatchPaths reports a change of the paths watched besides the
+	// working directory, which requires walking them again and updating
+	// the files whose changes are reported.
+	WatchPaths bool
+	// OnChangeRules reports changes to the on_change rules.
+	OnChangeRules bool
+	// Process reports changes to the server or exec command, its
+	// environment or its quit signal, which require restarting it.
+	Process bool
+}
+
+// Compare returns the differences between the configurations a and b.
+func Compare(a, b Config) Changes {
+	return Changes{
+		Mode: a.Mode() != b.Mode(),
+		Watcher: a.Watcher() != b.Watcher() ||
+			a.PollInterval() != b.PollInterval() ||
+			a.PollHash() != b.PollHash() ||
+			a.PollOverWatchLimit() != b.PollOverWatchLimit(),
+		LogLevel: a.LogLevel() != b.LogLevel(),
+		WatchFilters: !equalRegexes(a.IncludeFileRegexes(), b.IncludeFileRegexes()) ||
+			!equalRegexes(a.ExcludeFileRegexes(), b.ExcludeFileRegexes()) ||
+			!slices.Equal(a.EnvFiles(), b.EnvFiles()) ||
+			!slices.EqualFunc(a.OnChangeRules(), b.OnChangeRules(), func(x, y OnChangeRule) bool {
+				return equalRegexes(x.IncludeFileRegexes, y.IncludeFileRegexes)
+			}),
+		IgnoreDirectories: !slices.Equal(a.IgnoreDirectories(), b.IgnoreDirectories()),
+		WatchPaths:        !slices.Equal(a.WatchPaths(), b.WatchPaths()),
+		OnChangeRules:     !slices.EqualFunc(a.OnChangeRules(), b.OnChangeRules(), equalOnChangeRules),
+		Process: a.ServerCommand() != b.ServerCommand() ||
+			a.ExecCommand() != b.ExecCommand() ||
+			!maps.Equal(a.Env(), b.Env()) ||
+			!slices.Equal(a.EnvFiles(), b.EnvFiles()) ||
+			a.ClearEnv() != b.ClearEnv() ||
+			a.QuitSignal() != b.QuitSignal(),
+	}
+}
+
+func equalRegexes(a, b []regexp.Regexp) bool {
+	return slices.EqualFunc(a, b, func(x, y regexp.Regexp) bool {
+		return x.String() == y.String()
+	})
+}
+
+func equalOnChangeRules(a, b OnChangeRule) bool {
+	return a.Command == b.Command &&
+		a.Restart == b.Restart &&
+		equalRegexes(a.IncludeFileRegexes, b.IncludeFileRegexes) &&
+		equalRegexes(a.ExcludeFileRegexes, b.ExcludeFileRegexes)
+}
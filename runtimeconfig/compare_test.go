@@ -0,0 +1,76 @@
+package runtimeconfig_test
+
+import (
+	"testing"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	type testConfig struct {
+		desc     string
+		args     []string
+		expected runtimeconfig.Changes
+	}
+	base := []string{"-s", "./app", "-i", `\.go$`}
+	testConfigs := []testConfig{
+		{
+			desc: "no changes",
+			args: base,
+		},
+		{
+			desc:     "server command",
+			args:     []string{"-s", "./other-app", "-i", `\.go$`},
+			expected: runtimeconfig.Changes{Process: true},
+		},
+		{
+			desc:     "include file regexes",
+			args:     []string{"-s", "./app", "-i", `\.go$`, "-i", `\.tmpl$`},
+			expected: runtimeconfig.Changes{WatchFilters: true},
+		},
+		{
+			desc:     "log level",
+			args:     append([]string{"-l", "DEBUG"}, base...),
+			expected: runtimeconfig.Changes{LogLevel: true},
+		},
+		{
+			desc:     "mode",
+			args:     append([]string{"-mode", "exec", "-x", "go test ./..."}, base...),
+			expected: runtimeconfig.Changes{Mode: true, Process: true},
+		},
+		{
+			desc:     "watcher",
+			args:     append([]string{"-watcher", "poll"}, base...),
+			expected: runtimeconfig.Changes{Watcher: true},
+		},
+		{
+			desc:     "preset",
+			args:     append([]string{"-preset", "node"}, base...),
+			expected: runtimeconfig.Changes{WatchFilters: true, IgnoreDirectories: true, Process: true},
+		},
+	}
+	tempDir := t.TempDir()
+	a, err := runtimeconfig.Build(append([]string{"-d", tempDir}, base...))
+	if err != nil {
+		assert.FailNow(t, "Unexpected error", err)
+	}
+	for _, cfg := range testConfigs {
+		t.Run(
+			cfg.desc,
+			func(t *testing.T) {
+				// Setup
+				b, err := runtimeconfig.Build(append([]string{"-d", tempDir}, cfg.args...))
+				if err != nil {
+					assert.FailNow(t, "Unexpected error", err)
+				}
+
+				// Code under test
+				changes := runtimeconfig.Compare(a, b)
+
+				// Validate
+				assert.Equal(t, cfg.expected, changes)
+			},
+		)
+	}
+}
@@ -4,18 +4,44 @@ import (
 	"fmt"
 	"regexp"
 	"syscall"
+	"time"
 
 	"github.com/jakewan/go-procrotator/logger"
 )
 
 type Config interface {
 	fmt.Stringer
+	BaseConfigFiles() []string
+	ClearEnv() bool
+	ConfigFile() string
+	Env() map[string]string
+	EnvFiles() []string
+	ExecCommand() string
+	IgnoreDirectories() []string
+	WatchPaths() []string
 	IncludeFileRegexes() []regexp.Regexp
 	ExcludeFileRegexes() []regexp.Regexp
 	LogLevel() logger.LogLevel
+	Mode() Mode
+	OnChangeRules() []OnChangeRule
 	PreambleCommands() []string
+	Preset() Preset
+	Profiles() []string
+	Preambles() []Preamble
 	QuitSignal() syscall.Signal
+	ReadinessCommand() string
+	ReadinessTimeout() time.Duration
+	RestartStrategy() RestartStrategy
+	// Roots are the working directory and the directories among the
+	// watch paths. File patterns match either the path of a file or its
+	// path relative to the deepest root holding it.
+	Roots() []string
 	ServerCommand() string
+	Settings() []Setting
+	Watcher() WatcherBackend
+	PollInterval() time.Duration
+	PollHash() bool
+	PollOverWatchLimit() bool
 	WorkingDirectory() string
 }
 
@@ -24,9 +50,113 @@ type config struct {
 	workingDirectory   string
 	includeFileRegexes []regexp.Regexp
 	excludeFileRegexes []regexp.Regexp
-	preambleCommands   []string
+	preambles          []Preamble
 	serverCommand      string
 	quitSignal         syscall.Signal
+	restartStrategy    RestartStrategy
+	readinessCommand   string
+	readinessTimeout   time.Duration
+	env                map[string]string
+	envFiles           []string
+	clearEnv           bool
+	onChangeRules      []OnChangeRule
+	mode               Mode
+	execCommand        string
+	preset             Preset
+	ignoreDirectories  []string
+	watchPaths         []string
+	roots              []string
+	watcher            WatcherBackend
+	pollInterval       time.Duration
+	pollHash           bool
+	pollOverWatchLimit bool
+	configFile         string
+	baseConfigFiles    []string
+	profiles           []string
+	origins            map[string]Origin
+}
+
+// Profiles implements Config.
+func (c *config) Profiles() []string {
+	return c.profiles
+}
+
+// ConfigFile implements Config.
+func (c *config) ConfigFile() string {
+	return c.configFile
+}
+
+// Watcher implements Config.
+func (c *config) Watcher() WatcherBackend {
+	return c.watcher
+}
+
+// PollInterval implements Config.
+func (c *config) PollInterval() time.Duration {
+	return c.pollInterval
+}
+
+// PollHash implements Config.
+func (c *config) PollHash() bool {
+	return c.pollHash
+}
+
+// PollOverWatchLimit implements Config.
+func (c *config) PollOverWatchLimit() bool {
+	return c.pollOverWatchLimit
+}
+
+// BaseConfigFiles implements Config.
+func (c *config) BaseConfigFiles() []string {
+	return c.baseConfigFiles
+}
+
+// origin returns where the setting key was defined.
+func (c *config) origin(key string) Origin {
+	if o, ok := c.origins[key]; ok {
+		return o
+	}
+	return Origin{Source: SourceDefault}
+}
+
+// IgnoreDirectories implements Config.
+func (c *config) IgnoreDirectories() []string {
+	return c.ignoreDirectories
+}
+
+// WatchPaths implements Config.
+func (c *config) WatchPaths() []string {
+	return c.watchPaths
+}
+
+// Preset implements Config.
+func (c *config) Preset() Preset {
+	return c.preset
+}
+
+// ExecCommand implements Config.
+func (c *config) ExecCommand() string {
+	return c.execCommand
+}
+
+// Mode implements Config.
+func (c *config) Mode() Mode {
+	return c.mode
+}
+
+// ClearEnv implements Config.
+func (c *config) ClearEnv() bool {
+	return c.clearEnv
+}
+
+// Env implements Config.
+func (c *config) Env() map[string]string {
+	return c.env
+}
+
+// EnvFiles implements Config.
+func (c *config) EnvFiles() []string {
+	return c.envFiles
 }
 
 // ExcludeFileRegexes implements Config.
@@ -46,9 +176,13 @@ func (c *config) LogLevel() logger.LogLevel {
 
 // String implements cmd.Config.
 func (c *config) String() string {
-	preambleCommands := make([]string, 0, len(c.preambleCommands))
-	for _, s := range c.preambleCommands {
-		preambleCommands = append(preambleCommands, fmt.Sprintf("'%s'", s))
+	preambleCommands := make([]string, 0, len(c.preambles))
+	for _, p := range c.preambles {
+		if p.Routed() {
+			preambleCommands = append(preambleCommands, fmt.Sprintf("'%s' (routed)", p.Command))
+		} else {
+			preambleCommands = append(preambleCommands, fmt.Sprintf("'%s'", p.Command))
+		}
 	}
 	includeFileRegexes := make([]string, 0, len(c.includeFileRegexes))
 	for _, r := range c.includeFileRegexes {
@@ -65,24 +199,66 @@ func (c *config) String() string {
 		)
 	}
 	return fmt.Sprintf(`Config:
+  Config file: %s
+  Base config files: %s
+  Profiles: %s
   Working directory: %s
   Log level: %s
+  Mode: %s
+  Preset: %s
   Server command: %s
+  Exec command: %s
+  Restart strategy: %s
+  Readiness command: %s
   Preamble commands: %s
+  Environment files: %s
+  Clear environment: %t
+  On change rules: %d
   Include file regexes: %s
-  Exclude file regexes: %s`,
+  Exclude file regexes: %s
+  Ignored directories: %s
+  Watch paths: %s
+  Watcher: %s`,
+		c.configFile,
+		c.baseConfigFiles,
+		c.profiles,
 		c.workingDirectory,
 		c.logLevel,
+		c.mode,
+		c.preset,
 		c.serverCommand,
+		c.execCommand,
+		c.restartStrategy,
+		c.readinessCommand,
 		preambleCommands,
+		c.envFiles,
+		c.clearEnv,
+		len(c.onChangeRules),
 		includeFileRegexes,
 		excludeFileRegexes,
+		c.ignoreDirectories,
+		c.watchPaths,
+		c.watcher,
 	)
 }
 
+// OnChangeRules implements Config.
+func (c *config) OnChangeRules() []OnChangeRule {
+	return c.onChangeRules
+}
+
 // PreambleCommands implements cmd.Config.
 func (c *config) PreambleCommands() []string {
-	return c.preambleCommands
+	result := make([]string, 0, len(c.preambles))
+	for _, p := range c.preambles {
+		result = append(result, p.Command)
+	}
+	return result
+}
+
+// Preambles implements Config.
+func (c *config) Preambles() []Preamble {
+	return c.preambles
 }
 
 // QuitSignal implements cmd.Config.
@@ -90,6 +266,26 @@ func (c *config) QuitSignal() syscall.Signal {
 	return c.quitSignal
 }
 
+// ReadinessCommand implements cmd.Config.
+func (c *config) ReadinessCommand() string {
+	return c.readinessCommand
+}
+
+// ReadinessTimeout implements cmd.Config.
+func (c *config) ReadinessTimeout() time.Duration {
+	return c.readinessTimeout
+}
+
+// RestartStrategy implements cmd.Config.
+func (c *config) RestartStrategy() RestartStrategy {
+	return c.restartStrategy
+}
+
+// Roots implements cmd.Config.
+func (c *config) Roots() []string {
+	return c.roots
+}
+
 // ServerCommand implements cmd.Config.
 func (c *config) ServerCommand() string {
 	return c.serverCommand
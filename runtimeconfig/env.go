@@ -0,0 +1,115 @@
+package runtimeconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix prefixes the names of the environment variables overriding
+// config file settings.
+const envPrefix = "PROCROTATOR_"
+
+// envVarName returns the name of the environment variable overriding the
+// config file setting key.
+func envVarName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// applyEnv overrides the settings of d with the environment variables
+// named by envVarName that are set and not empty. String settings take
+// the value as is. Other values are written in TOML syntax, except that
+// a list holding a single string may be given as that string.
+func (d *tomlConfig) applyEnv() error {
+	var problems []*Problem
+	for _, key := range settingKeys() {
+		name := envVarName(key)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		tomlValue, err := envTOMLValue(key, value)
+		if err != nil {
+			problems = append(problems, &Problem{Err: fmt.Errorf("%s: %w", name, err)})
+			continue
+		}
+		if settingFields[key].Type.Kind() == reflect.Slice {
+			// The variable replaces the list rather than being decoded
+			// over it.
+			d.resetList(key)
+		}
+		md, err := toml.Decode(key+" = "+tomlValue, d)
+		if err != nil {
+			problems = append(problems, &Problem{Err: fmt.Errorf("%s: %w", name, envDecodeError(err))})
+			continue
+		}
+		for _, k := range md.Undecoded() {
+			if k[0] == "preamble_commands" {
+				continue
+			}
+			problems = append(problems, &Problem{Err: fmt.Errorf(
+				"%s: %w",
+				name,
+				unknownKeyError(strings.Join(k, "."), k[len(k)-1], tableKeys[strings.Join(k[:len(k)-1], ".")]),
+			)})
+		}
+		if d.envVars == nil {
+			d.envVars = map[string]string{}
+		}
+		d.envVars[key] = name
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// envTOMLValue returns the TOML rendering of the value of the environment
+// variable overriding the setting key.
+func envTOMLValue(key, value string) (string, error) {
+	switch settingFields[key].Type.Kind() {
+	case reflect.String:
+		return FormatTOMLValue(value)
+	case reflect.Slice:
+		if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			return FormatTOMLValue([]string{value})
+		}
+	}
+	return value, nil
+}
+
+// envDecodeError strips the position from err, returned when decoding the
+// value of an environment variable, since the value is a single line.
+func envDecodeError(err error) error {
+	var pe toml.ParseError
+	if errors.As(err, &pe) {
+		message := pe.Message
+		if message == "" {
+			// Errors of toml.Unmarshaler implementations.
+			message = strings.TrimPrefix(pe.Error(), fmt.Sprintf("toml: line %d: ", pe.Position.Line))
+			if m := decodeErrorPattern.FindStringSubmatch(pe.Error()); m != nil {
+				message = m[3]
+			}
+		}
+		return errors.New(message)
+	} else if m := decodeErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		return errors.New(m[3])
+	}
+	return err
+}
+
+// origin returns the origin of the setting key when it is defined in the
+// environment or the config files.
+func (d *tomlConfig) origin(key string) (Origin, bool) {
+	if name, ok := d.envVars[key]; ok {
+		return Origin{Source: SourceEnv, Variable: name}, true
+	}
+	if f, path, ok := d.definition(key); ok {
+		return Origin{Source: SourceFile, File: f.path, Line: keyLine(f.lines, path)}, true
+	}
+	return Origin{}, false
+}
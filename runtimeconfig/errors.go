@@ -0,0 +1,92 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigError is returned by Build when the configuration is invalid, as
+// opposed to when it cannot be read.
+type ConfigError struct {
+	Problems []*Problem
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		messages = append(messages, p.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Problem is a single problem with the configuration. File and Line
+// locate it in the config file when known.
+type Problem struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (p *Problem) Error() string {
+	if p.File == "" {
+		return p.Err.Error()
+	} else if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Err)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Err)
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// invalid returns err as a problem with the configuration that is not
+// tied to a location in the config file.
+func invalid(err error) error {
+	return &ConfigError{Problems: []*Problem{{Err: err}}}
+}
+
+// unknownKeyError reports the config file key, whose last component is
+// name, as not part of the schema, suggesting the closest of known.
+func unknownKeyError(key, name string, known []string) error {
+	return fmt.Errorf("unknown key %s%s", key, didYouMean(name, known))
+}
+
+// didYouMean returns a suggestion of the entry of known closest to name,
+// or an empty string if none is close enough to be a likely typo.
+func didYouMean(name string, known []string) string {
+	var (
+		best         string
+		bestDistance int
+	)
+	for _, k := range known {
+		if d := editDistance(name, k); best == "" || d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	if best == "" || bestDistance > max(2, len(name)/3) {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
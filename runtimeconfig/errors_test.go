@@ -0,0 +1,251 @@
+package runtimeconfig_test
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildConfigError(t *testing.T) {
+	type testConfig struct {
+		desc     string
+		fileName string
+		content  string
+		args     []string
+		expected []string
+	}
+	testConfigs := []testConfig{
+		{
+			desc: "valid config",
+			content: `include_file_regexes = ["\\.go$"]
+server_command = "./app"
+`,
+		},
+		{
+			desc: "unknown keys",
+			content: `include_file_regexes = ["\\.go$"]
+server_comand = "./app"
+
+[[on_change]]
+command = "make assets"
+include_file_regexes = ["\\.css$"]
+restrat = false
+unrelated = 1
+`,
+			expected: []string{
+				"%s:2: unknown key server_comand (did you mean server_command?)",
+				"%s:7: unknown key on_change.restrat (did you mean restart?)",
+				"%s:8: unknown key on_change.unrelated",
+			},
+		},
+		{
+			desc:     "unknown keys in yaml",
+			fileName: "procrotator.yaml",
+			content: `server_comand: ./app
+on_change:
+  - command: make assets
+    include_file_regexes: ['\\.css$']
+    restrat: false
+`,
+			expected: []string{
+				"%s:1: unknown key server_comand (did you mean server_command?)",
+				"%s:5: unknown key on_change.restrat (did you mean restart?)",
+			},
+		},
+		{
+			desc:     "invalid type in yaml",
+			fileName: "procrotator.yaml",
+			content: `server_command: ./app
+env:
+  PORT: 8080
+`,
+			expected: []string{
+				"%s:3: incompatible types",
+			},
+		},
+		{
+			desc: "unknown key in profile",
+			content: `server_command = "./app"
+
+[profile.debug]
+server_comand = "dlv exec ./app"
+`,
+			expected: []string{
+				"%s:4: unknown key profile.debug.server_comand (did you mean server_command?)",
+			},
+		},
+		{
+			desc: "unknown preamble key",
+			content: `server_command = "./app"
+preamble_commands = [
+  { name = "build", comand = "go build ." },
+]
+`,
+			expected: []string{
+				"%s:2: unexpected preamble command key: comand (did you mean command?)",
+			},
+		},
+		{
+			desc: "invalid value from flags",
+			content: `server_command = "./app"
+[env]
+PORT = "8080"
+`,
+			args: []string{"-mode", "exec"},
+			expected: []string{
+				"exec command required in exec mode",
+			},
+		},
+		{
+			desc:    "unknown flag",
+			content: `server_command = "./app"`,
+			args:    []string{"-nosuchflag"},
+			expected: []string{
+				"flag provided but not defined: -nosuchflag",
+			},
+		},
+		{
+			desc:    "invalid flag value",
+			content: `server_command = "./app"`,
+			args:    []string{"-mode", "sometimes"},
+			expected: []string{
+				`invalid value "sometimes" for flag -mode`,
+			},
+		},
+		{
+			desc: "invalid value in file",
+			content: `server_command = "./app"
+
+  restart_strategy = "sometimes"
+`,
+			expected: []string{
+				"%s:3: restart_strategy value not supported: sometimes",
+			},
+		},
+		{
+			desc: "syntax error",
+			content: `server_command = "./app
+`,
+			expected: []string{
+				"%s:1: ",
+			},
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(
+			cfg.desc,
+			func(t *testing.T) {
+				// Setup
+				tempDir := t.TempDir()
+				fileName := "procrotator.toml"
+				if cfg.fileName != "" {
+					fileName = cfg.fileName
+				}
+				path := filepath.Join(tempDir, fileName)
+				if err := os.WriteFile(path, []byte(cfg.content), 0666); err != nil {
+					assert.FailNow(t, "Error writing config file", err)
+				}
+
+				// Code under test
+				_, err := runtimeconfig.Build(append([]string{"-d", tempDir}, cfg.args...))
+
+				// Validate
+				if len(cfg.expected) == 0 {
+					assert.NoError(t, err)
+					return
+				}
+				var ce *runtimeconfig.ConfigError
+				if assert.ErrorAs(t, err, &ce) && assert.Len(t, ce.Problems, len(cfg.expected)) {
+					for i, p := range ce.Problems {
+						assert.Contains(t, p.Error(), fmtPath(cfg.expected[i], path))
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestBuildHelp(t *testing.T) {
+	// Code under test
+	_, err := runtimeconfig.Build([]string{"-d", t.TempDir(), "-h"})
+
+	// Validate
+	var ce *runtimeconfig.ConfigError
+	assert.ErrorIs(t, err, flag.ErrHelp)
+	assert.False(t, errors.As(err, &ce))
+}
+
+func TestBuildReadError(t *testing.T) {
+	// Setup
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, ".procrotator.toml"), 0777); err != nil {
+		assert.FailNow(t, "Error creating directory", err)
+	}
+
+	// Code under test
+	_, err := runtimeconfig.Build([]string{"-d", tempDir, "-s", "./app"})
+
+	// Validate
+	var ce *runtimeconfig.ConfigError
+	if assert.Error(t, err) {
+		assert.False(t, errors.As(err, &ce))
+		assert.ErrorContains(t, err, "reading config file")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	// Setup
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "procrotator.toml")
+	if err := os.WriteFile(path, []byte(`include_file_regexes = ["\\.go$"]
+server_command = "./app"
+`), 0666); err != nil {
+		assert.FailNow(t, "Error writing config file", err)
+	}
+	c, err := runtimeconfig.Build([]string{"-d", tempDir, "-s", "./other", "-preset", "go"})
+	if err != nil {
+		assert.FailNow(t, "Unexpected error", err)
+	}
+
+	// Code under test
+	toml, tomlErr := runtimeconfig.FormatTOML(c)
+	b, err := runtimeconfig.FormatJSON(c)
+
+	// Validate
+	assert.NoError(t, tomlErr)
+	assert.Contains(t, toml, `include_file_regexes = ["\\.go$"]  # file `+path+":1\n")
+	assert.Contains(t, toml, `server_command = "./other"  # flag`+"\n")
+	assert.Contains(t, toml, `quit_signal = "SIGTERM"  # preset`+"\n")
+	assert.Contains(t, toml, `restart_strategy = "stop-then-start"  # default`+"\n")
+	if assert.NoError(t, err) {
+		var doc struct {
+			ConfigFile string `json:"config_file"`
+			Settings   map[string]struct {
+				Value  any    `json:"value"`
+				Source string `json:"source"`
+				File   string `json:"file"`
+				Line   int    `json:"line"`
+			} `json:"settings"`
+		}
+		if assert.NoError(t, json.Unmarshal(b, &doc)) {
+			assert.Equal(t, path, doc.ConfigFile)
+			assert.Equal(t, "file", doc.Settings["include_file_regexes"].Source)
+			assert.Equal(t, 1, doc.Settings["include_file_regexes"].Line)
+			assert.Equal(t, "flag", doc.Settings["server_command"].Source)
+			assert.Equal(t, "./other", doc.Settings["server_command"].Value)
+			assert.Equal(t, "preset", doc.Settings["ignore_directories"].Source)
+		}
+	}
+}
+
+// fmtPath substitutes path for the %s placeholder of s, if any.
+func fmtPath(s, path string) string {
+	return strings.Replace(s, "%s", path, 1)
+}
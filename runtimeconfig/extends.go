@@ -0,0 +1,156 @@
+package runtimeconfig
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Merge strategies of the merge_strategy table, which determine how a
+// list setting of a config file is merged with the value it inherits
+// from the files it extends.
+const (
+	mergeReplace = "replace"
+	mergeAppend  = "append"
+)
+
+// loadBases loads the config files listed in the extends key of d.
+// Relative paths are resolved against the directory of d. extendedBy
+// lists the absolute paths of d and the files extending it, in order, to
+// detect cycles.
+func (d *tomlConfig) loadBases(extendedBy []string) error {
+	for _, e := range d.Extends {
+		path := e
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(d.path), path)
+		}
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if i := slices.Index(extendedBy, absPath); i >= 0 {
+			return d.errorAt("extends", fmt.Errorf(
+				"config files extend each other: %s",
+				strings.Join(append(slices.Clone(extendedBy[i:]), absPath), " -> "),
+			))
+		}
+		if !slices.Contains(configFileExtensions(), filepath.Ext(path)) {
+			return d.errorAt("extends", fmt.Errorf(
+				"extended file %s is not a config file, expected one of the extensions %s",
+				e,
+				strings.Join(configFileExtensions(), ", "),
+			))
+		}
+		base, err := loadConfigFile(path, extendedBy)
+		if err != nil {
+			var ce *ConfigError
+			if errors.As(err, &ce) {
+				return err
+			}
+			return d.errorAt("extends", fmt.Errorf("reading extended config file: %w", err))
+		}
+		d.bases = append(d.bases, base)
+	}
+	return nil
+}
+
+// checkMergeStrategy validates the merge_strategy table of d.
+func (d *tomlConfig) checkMergeStrategy() error {
+	lists := listSettingKeys()
+	for _, key := range slices.Sorted(maps.Keys(d.MergeStrategy)) {
+		if !slices.Contains(lists, key) {
+			return d.errorAt("merge_strategy."+key, unknownKeyError("merge_strategy."+key, key, lists))
+		}
+		switch d.MergeStrategy[key] {
+		case mergeReplace, mergeAppend:
+		default:
+			return d.errorAt("merge_strategy."+key, fmt.Errorf(
+				"merge_strategy value not supported: %s",
+				d.MergeStrategy[key],
+			))
+		}
+	}
+	return nil
+}
+
+// chain returns the config files d is made of in merge order: the files
+// it extends, each preceded by the files they extend, followed by d. A
+// file extended more than once is merged at its first occurrence only.
+func (d *tomlConfig) chain() []*tomlConfig {
+	var result []*tomlConfig
+	var add func(f *tomlConfig)
+	add = func(f *tomlConfig) {
+		for _, b := range f.bases {
+			add(b)
+		}
+		if !slices.ContainsFunc(result, func(r *tomlConfig) bool {
+			return r.absPath == f.absPath
+		}) {
+			result = append(result, f)
+		}
+	}
+	add(d)
+	return result
+}
+
+// merge sets the settings of d to those of the files of its chain,
+// merged in order, with the named profiles applied. Settings of a file
+// replace those it inherits, except that the entries of tables such as
+// env are merged and lists are appended to when the merge_strategy of
+// the file says so.
+func (d *tomlConfig) merge(profiles []string) error {
+	chain := d.chain()
+	var merged tomlConfig
+	for _, f := range chain {
+		inherited := map[string]reflect.Value{}
+		for _, key := range listSettingKeys() {
+			if f.meta.IsDefined(key) {
+				if f.MergeStrategy[key] == mergeAppend {
+					inherited[key] = reflect.ValueOf(merged.setting(key).Interface())
+				}
+				merged.resetList(key)
+			}
+		}
+		if _, err := toml.Decode(f.doc, &merged); err != nil {
+			return f.decodeError(err)
+		}
+		for key, v := range inherited {
+			s := merged.setting(key)
+			s.Set(reflect.AppendSlice(v, s))
+		}
+	}
+	if err := applyProfiles(&merged, chain, profiles); err != nil {
+		return err
+	}
+	for _, key := range settingKeys() {
+		d.setting(key).Set(merged.setting(key))
+	}
+	d.profiles = profiles
+	return nil
+}
+
+// resetList clears the list setting key of d before decoding a new value
+// onto it. The decoder reuses the elements of a slice, so elements of
+// the previous value would otherwise keep the fields the new value does
+// not set.
+func (d *tomlConfig) resetList(key string) {
+	d.setting(key).SetZero()
+}
+
+// baseFiles returns the absolute paths of the config files extended by
+// d, directly or indirectly, in merge order.
+func (d *tomlConfig) baseFiles() []string {
+	var result []string
+	for _, f := range d.chain() {
+		if f != d {
+			result = append(result, f.absPath)
+		}
+	}
+	return result
+}
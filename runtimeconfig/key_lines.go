@@ -0,0 +1,95 @@
+package runtimeconfig
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// tomlKeyLines returns the line on which each key of the TOML document
+// content is first defined. Keys are dotted paths such as "env.PORT".
+// Table headers record the line of the table itself. Keys of inline
+// tables are not recorded; lookups fall back to the enclosing key.
+func tomlKeyLines(content []byte) map[string]int {
+	result := map[string]int{}
+	record := func(key string, line int) {
+		if _, ok := result[key]; !ok {
+			result[key] = line
+		}
+	}
+	var (
+		table    string
+		depth    int
+		inString rune
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if depth == 0 && inString == 0 {
+			trimmed := strings.TrimSpace(text)
+			if strings.HasPrefix(trimmed, "[") {
+				if end := strings.LastIndex(trimmed, "]"); end > 0 {
+					table = tomlKeyPath(strings.Trim(trimmed[:end+1], "[]"))
+					record(table, line)
+					continue
+				}
+			}
+			if eq := strings.Index(trimmed, "="); eq > 0 && !strings.HasPrefix(trimmed, "#") {
+				key := tomlKeyPath(trimmed[:eq])
+				if table != "" {
+					key = table + "." + key
+				}
+				record(key, line)
+			}
+		}
+		// Track multi-line arrays, inline tables and strings so that their
+		// contents are not mistaken for keys.
+	chars:
+		for i, r := range text {
+			switch {
+			case inString != 0:
+				if r == inString && (i == 0 || text[i-1] != '\\' || inString == '\'') {
+					inString = 0
+				}
+			case r == '"' || r == '\'':
+				inString = r
+			case r == '#':
+				break chars
+			case r == '[' || r == '{':
+				depth++
+			case r == ']' || r == '}':
+				depth--
+			}
+		}
+		// Only multi-line strings continue past the end of a line.
+		if inString != 0 && !strings.Contains(text, `"""`) && !strings.Contains(text, "'''") {
+			inString = 0
+		}
+	}
+	return result
+}
+
+// tomlKeyPath normalizes a possibly dotted and quoted TOML key.
+func tomlKeyPath(s string) string {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	for i, p := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(p), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// keyLine returns the line of key in lines, falling back to the closest
+// enclosing key, or 0 if none is known.
+func keyLine(lines map[string]int, key string) int {
+	for key != "" {
+		if line, ok := lines[key]; ok {
+			return line
+		}
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			key = key[:i]
+		} else {
+			key = ""
+		}
+	}
+	return 0
+}
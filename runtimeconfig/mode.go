@@ -0,0 +1,39 @@
+package runtimeconfig
+
+import "fmt"
+
+// Mode determines what go-procrotator runs when watched files change.
+type Mode int
+
+const (
+	// ServerMode keeps a long-lived server process running and restarts
+	// it when watched files change.
+	ServerMode Mode = iota
+	// ExecMode runs a command to completion whenever watched files
+	// change, such as a test suite.
+	ExecMode
+)
+
+func (r Mode) String() string {
+	return [...]string{"server", "exec"}[r]
+}
+
+func (r Mode) EnumIndex() int {
+	return int(r)
+}
+
+func allModes() []Mode {
+	return []Mode{
+		ServerMode,
+		ExecMode,
+	}
+}
+
+func parseMode(s string) (Mode, error) {
+	for _, m := range allModes() {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return ServerMode, fmt.Errorf("mode value not supported: %s", s)
+}
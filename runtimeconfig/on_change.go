@@ -0,0 +1,50 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// OnChangeRule runs a command when matching files change.
+type OnChangeRule struct {
+	Command            string
+	IncludeFileRegexes []regexp.Regexp
+	ExcludeFileRegexes []regexp.Regexp
+	// Restart reports whether matching changes should also restart the
+	// server. When false, matching changes only run the command.
+	Restart bool
+}
+
+// Matches reports whether a change to path triggers the rule, given the
+// roots of the configuration.
+func (r OnChangeRule) Matches(path string, roots []string) bool {
+	return matchesFilePatterns(r.IncludeFileRegexes, r.ExcludeFileRegexes, path, roots)
+}
+
+// onChangeSpec is an [[on_change]] table as written in the config file.
+type onChangeSpec struct {
+	Command            string   `toml:"command"`
+	IncludeFileRegexes []string `toml:"include_file_regexes"`
+	ExcludeFileRegexes []string `toml:"exclude_file_regexes"`
+	Restart            *bool    `toml:"restart"`
+}
+
+func (o onChangeSpec) build() (OnChangeRule, error) {
+	result := OnChangeRule{
+		Command: o.Command,
+		Restart: o.Restart == nil || *o.Restart,
+	}
+	if o.Command == "" {
+		return result, fmt.Errorf("on_change rule requires a command")
+	} else if len(o.IncludeFileRegexes) < 1 {
+		return result, fmt.Errorf("on_change rule for %q requires include_file_regexes", o.Command)
+	}
+	var err error
+	if result.IncludeFileRegexes, err = compileRegexes(o.IncludeFileRegexes); err != nil {
+		return result, fmt.Errorf("parsing include file expressions for %q: %w", o.Command, err)
+	}
+	if result.ExcludeFileRegexes, err = compileRegexes(o.ExcludeFileRegexes); err != nil {
+		return result, fmt.Errorf("parsing exclude file expressions for %q: %w", o.Command, err)
+	}
+	return result, nil
+}
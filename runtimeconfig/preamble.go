@@ -0,0 +1,304 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+// Preamble is a command run before the server command.
+type Preamble struct {
+	// Name identifies the preamble in depends_on lists and logs. It
+	// defaults to the command.
+	Name    string
+	Command string
+	// DependsOn names the preambles that must complete before this one
+	// starts. A preamble that does not declare depends_on depends on the
+	// preamble preceding it, so plain lists of commands run in order.
+	DependsOn []string
+	// IncludeFileRegexes and ExcludeFileRegexes restrict the preamble to
+	// changes of matching files. A preamble without any regexes runs on
+	// every restart.
+	IncludeFileRegexes []regexp.Regexp
+	ExcludeFileRegexes []regexp.Regexp
+	// Inputs are glob patterns of the files the preamble reads, and
+	// Outputs the files and directories it writes. A preamble declaring
+	// inputs is skipped when its inputs and outputs are unchanged since
+	// its last successful run.
+	Inputs  []string
+	Outputs []string
+}
+
+// Cacheable reports whether the preamble declares inputs for the build
+// cache.
+func (p Preamble) Cacheable() bool {
+	return len(p.Inputs) > 0
+}
+
+// Routed reports whether the preamble declares its own file patterns.
+func (p Preamble) Routed() bool {
+	return len(p.IncludeFileRegexes) > 0 || len(p.ExcludeFileRegexes) > 0
+}
+
+// Matches reports whether a change to path should run the preamble,
+// given the roots of the configuration.
+func (p Preamble) Matches(path string, roots []string) bool {
+	return matchesFilePatterns(p.IncludeFileRegexes, p.ExcludeFileRegexes, path, roots)
+}
+
+// ShouldRun reports whether the preamble should run for a restart caused
+// by changes to changedFiles, given the roots of the configuration. Every
+// preamble runs when changedFiles is empty, such as on the initial start.
+func (p Preamble) ShouldRun(changedFiles, roots []string) bool {
+	if !p.Routed() || len(changedFiles) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(changedFiles, func(path string) bool {
+		return p.Matches(path, roots)
+	})
+}
+
+// preambleSpec is a preamble command as written in the config file:
+// either a plain command string or a table with a command and file
+// patterns.
+type preambleSpec struct {
+	Name               string
+	Command            string
+	DependsOn          []string
+	dependsOnSet       bool
+	IncludeFileRegexes []string
+	ExcludeFileRegexes []string
+	Inputs             []string
+	Outputs            []string
+}
+
+// preambleKeys are the keys of a preamble command table.
+var preambleKeys = []string{
+	"name",
+	"command",
+	"depends_on",
+	"include_file_regexes",
+	"exclude_file_regexes",
+	"inputs",
+	"outputs",
+}
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (p *preambleSpec) UnmarshalTOML(data any) error {
+	switch v := data.(type) {
+	case string:
+		p.Command = v
+		return nil
+	case map[string]any:
+		for k, item := range v {
+			var err error
+			switch k {
+			case "name":
+				p.Name, err = stringValue(k, item)
+			case "command":
+				p.Command, err = stringValue(k, item)
+			case "depends_on":
+				p.DependsOn, err = stringSliceValue(k, item)
+				p.dependsOnSet = true
+			case "include_file_regexes":
+				p.IncludeFileRegexes, err = stringSliceValue(k, item)
+			case "exclude_file_regexes":
+				p.ExcludeFileRegexes, err = stringSliceValue(k, item)
+			case "inputs":
+				p.Inputs, err = stringSliceValue(k, item)
+			case "outputs":
+				p.Outputs, err = stringSliceValue(k, item)
+			default:
+				err = fmt.Errorf("unexpected preamble command key: %s%s", k, didYouMean(k, preambleKeys))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if p.Command == "" {
+			return fmt.Errorf("preamble command table requires a command")
+		}
+		return nil
+	default:
+		return fmt.Errorf("preamble command must be a string or a table (got %T)", data)
+	}
+}
+
+// tomlValue returns the preamble in the form accepted by UnmarshalTOML.
+func (p preambleSpec) tomlValue() any {
+	if p.Name == "" && !p.dependsOnSet && len(p.IncludeFileRegexes) == 0 &&
+		len(p.ExcludeFileRegexes) == 0 && len(p.Inputs) == 0 && len(p.Outputs) == 0 {
+		return p.Command
+	}
+	result := map[string]any{"command": p.Command}
+	if p.Name != "" {
+		result["name"] = p.Name
+	}
+	if p.dependsOnSet {
+		result["depends_on"] = p.DependsOn
+	}
+	for k, v := range map[string][]string{
+		"include_file_regexes": p.IncludeFileRegexes,
+		"exclude_file_regexes": p.ExcludeFileRegexes,
+		"inputs":               p.Inputs,
+		"outputs":              p.Outputs,
+	} {
+		if len(v) > 0 {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func (p preambleSpec) build() (Preamble, error) {
+	result := Preamble{
+		Name:      p.Name,
+		Command:   p.Command,
+		DependsOn: p.DependsOn,
+		Inputs:    p.Inputs,
+		Outputs:   p.Outputs,
+	}
+	var err error
+	if result.IncludeFileRegexes, err = compileRegexes(p.IncludeFileRegexes); err != nil {
+		return result, fmt.Errorf("parsing include file expressions for %q: %w", p.Command, err)
+	}
+	if result.ExcludeFileRegexes, err = compileRegexes(p.ExcludeFileRegexes); err != nil {
+		return result, fmt.Errorf("parsing exclude file expressions for %q: %w", p.Command, err)
+	}
+	return result, nil
+}
+
+// resolvePreambles assigns default names, makes the implicit dependency
+// on the preceding preamble explicit, and verifies that the dependencies
+// form an acyclic graph.
+//
+// dependsOnSet reports, for each preamble, whether depends_on was
+// declared.
+func resolvePreambles(preambles []Preamble, dependsOnSet []bool) error {
+	names := map[string]int{}
+	for i, p := range preambles {
+		if p.Name == "" {
+			continue
+		}
+		if _, found := names[p.Name]; found {
+			return fmt.Errorf("duplicate preamble name: %s", p.Name)
+		}
+		names[p.Name] = i
+	}
+	for i := range preambles {
+		if preambles[i].Name != "" {
+			continue
+		}
+		name := preambles[i].Command
+		for n := 2; ; n++ {
+			if _, found := names[name]; !found {
+				break
+			}
+			name = fmt.Sprintf("%s#%d", preambles[i].Command, n)
+		}
+		preambles[i].Name = name
+		names[name] = i
+	}
+	for i := range preambles {
+		if !dependsOnSet[i] && i > 0 {
+			preambles[i].DependsOn = []string{preambles[i-1].Name}
+		}
+		for _, d := range preambles[i].DependsOn {
+			if _, found := names[d]; !found {
+				return fmt.Errorf("preamble %s depends on unknown preamble %s", preambles[i].Name, d)
+			}
+		}
+	}
+
+	// Depth-first search for cycles.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(preambles))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visiting:
+			start := slices.Index(path, preambles[i].Name)
+			cycle := append(slices.Clone(path[start:]), preambles[i].Name)
+			return fmt.Errorf("preamble dependency cycle: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		marks[i] = visiting
+		path = append(path, preambles[i].Name)
+		for _, d := range preambles[i].DependsOn {
+			if err := visit(names[d]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[i] = visited
+		return nil
+	}
+	for i := range preambles {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesFilePatterns reports whether path matches at least one of
+// include (or include is empty) and none of exclude. Like the watch
+// filters, the regexes match either path or its path relative to the
+// deepest of roots holding it.
+func matchesFilePatterns(include, exclude []regexp.Regexp, path string, roots []string) bool {
+	rel, ok := watchdirs.RelativePath(roots, path)
+	if !ok {
+		rel = path
+	}
+	matchFunc := func(r regexp.Regexp) bool {
+		return r.MatchString(path) || r.MatchString(rel)
+	}
+	if len(include) > 0 && !slices.ContainsFunc(include, matchFunc) {
+		return false
+	}
+	return !slices.ContainsFunc(exclude, matchFunc)
+}
+
+func compileRegexes(exprs []string) ([]regexp.Regexp, error) {
+	var result []regexp.Regexp
+	for _, s := range exprs {
+		if r, err := regexp.Compile(s); err != nil {
+			return nil, err
+		} else {
+			result = append(result, *r)
+		}
+	}
+	return result, nil
+}
+
+func stringValue(key string, v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("%s must be a string (got %T)", key, v)
+}
+
+func stringSliceValue(key string, v any) ([]string, error) {
+	items, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings (got %T)", key, v)
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		} else {
+			return nil, fmt.Errorf("%s must be an array of strings (got element %T)", key, item)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,44 @@
+package runtimeconfig_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreambleShouldRun(t *testing.T) {
+	unrouted := runtimeconfig.Preamble{Command: "go build ."}
+	proto := runtimeconfig.Preamble{
+		Command:            "buf generate",
+		IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.proto$`)},
+		ExcludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`/vendor/`)},
+	}
+	roots := []string{"/app"}
+	assert.True(t, unrouted.ShouldRun(nil, roots))
+	assert.True(t, unrouted.ShouldRun([]string{"/app/main.go"}, roots))
+	assert.True(t, proto.ShouldRun(nil, roots))
+	assert.False(t, proto.ShouldRun([]string{"/app/main.go"}, roots))
+	assert.True(t, proto.ShouldRun([]string{"/app/main.go", "/app/api/service.proto"}, roots))
+	assert.False(t, proto.ShouldRun([]string{"/app/vendor/service.proto"}, roots))
+}
+
+func TestPreambleShouldRunRoots(t *testing.T) {
+	// Like the watch filters, the regexes also match paths relative to
+	// the deepest root holding them.
+	p := runtimeconfig.Preamble{
+		Command:            "buf generate",
+		IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`^api/.*\.proto$`)},
+		ExcludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`^vendor/`)},
+	}
+	roots := []string{"/src/app", "/src/shared", "/src/app/third_party"}
+	assert.True(t, p.ShouldRun([]string{"/src/app/api/service.proto"}, roots))
+	assert.True(t, p.ShouldRun([]string{"/src/shared/api/types.proto"}, roots))
+	assert.True(t, p.ShouldRun([]string{"/src/app/third_party/api/lib.proto"}, roots))
+	assert.False(t, p.ShouldRun([]string{"/src/app/internal/api/service.proto"}, roots))
+	assert.False(t, p.ShouldRun([]string{"/src/shared/vendor/api/types.proto"}, roots))
+	assert.False(t, p.ShouldRun([]string{"/elsewhere/api/service.proto"}, roots))
+	// Without roots, only absolute paths are matched.
+	assert.False(t, p.ShouldRun([]string{"/src/app/api/service.proto"}, nil))
+}
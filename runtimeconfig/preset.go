@@ -0,0 +1,142 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preset tailors go-procrotator to a particular kind of project by
+// supplying defaults for settings the configuration does not specify.
+type Preset int
+
+const (
+	NoPreset Preset = iota
+	// GoPreset also maps changed files to the affected packages of the
+	// Go module in the working directory.
+	GoPreset
+	NodePreset
+	PythonPreset
+	RustPreset
+)
+
+func (r Preset) String() string {
+	return [...]string{"", "go", "node", "python", "rust"}[r]
+}
+
+func (r Preset) EnumIndex() int {
+	return int(r)
+}
+
+// AllPresets returns every preset other than NoPreset.
+func AllPresets() []Preset {
+	return []Preset{
+		GoPreset,
+		NodePreset,
+		PythonPreset,
+		RustPreset,
+	}
+}
+
+func parsePreset(s string) (Preset, error) {
+	for _, p := range AllPresets() {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	presetNames := make([]string, 0, len(AllPresets()))
+	for _, p := range AllPresets() {
+		presetNames = append(presetNames, p.String())
+	}
+	return NoPreset, fmt.Errorf(
+		"preset value not supported. expected one of: %s (got %s)",
+		strings.Join(presetNames, ", "),
+		s,
+	)
+}
+
+type presetDefaults struct {
+	includeFileRegexes []string
+	excludeFileRegexes []string
+	ignoreDirectories  []string
+	preambleCommands   []preambleSpec
+	quitSignal         string
+}
+
+func (r Preset) defaults() presetDefaults {
+	switch r {
+	case GoPreset:
+		return presetDefaults{
+			includeFileRegexes: []string{`\.go$`, `(^|/)go\.(mod|sum)$`},
+			ignoreDirectories:  []string{".git", "vendor", "testdata"},
+			preambleCommands:   []preambleSpec{{Command: "go build ."}},
+			quitSignal:         "SIGTERM",
+		}
+	case NodePreset:
+		return presetDefaults{
+			includeFileRegexes: []string{`\.(js|cjs|mjs|jsx|ts|cts|mts|tsx|json)$`},
+			excludeFileRegexes: []string{`(^|/)package-lock\.json$`},
+			ignoreDirectories:  []string{".git", "node_modules", "dist", "build", "coverage"},
+			preambleCommands: []preambleSpec{
+				{
+					Command:            "npm install",
+					IncludeFileRegexes: []string{`(^|/)package\.json$`},
+				},
+			},
+			quitSignal: "SIGTERM",
+		}
+	case PythonPreset:
+		return presetDefaults{
+			includeFileRegexes: []string{`\.py$`, `(^|/)requirements.*\.txt$`},
+			ignoreDirectories: []string{
+				".git", "__pycache__", ".venv", "venv", ".mypy_cache", ".pytest_cache",
+			},
+			preambleCommands: []preambleSpec{
+				{
+					Command:            "pip install -r requirements.txt",
+					IncludeFileRegexes: []string{`(^|/)requirements\.txt$`},
+				},
+			},
+			quitSignal: "SIGINT",
+		}
+	case RustPreset:
+		return presetDefaults{
+			includeFileRegexes: []string{`\.rs$`, `(^|/)Cargo\.(toml|lock)$`},
+			ignoreDirectories:  []string{".git", "target"},
+			preambleCommands:   []preambleSpec{{Command: "cargo build"}},
+			quitSignal:         "SIGTERM",
+		}
+	}
+	return presetDefaults{}
+}
+
+// Describe returns the settings supplied by the preset in config file
+// syntax.
+func (r Preset) Describe() (string, error) {
+	d := r.defaults()
+	preambleCommands := make([]any, 0, len(d.preambleCommands))
+	for _, p := range d.preambleCommands {
+		preambleCommands = append(preambleCommands, p.tomlValue())
+	}
+	settings := []struct {
+		key   string
+		value any
+	}{
+		{"include_file_regexes", d.includeFileRegexes},
+		{"exclude_file_regexes", d.excludeFileRegexes},
+		{"ignore_directories", d.ignoreDirectories},
+		{"preamble_commands", preambleCommands},
+		{"quit_signal", d.quitSignal},
+	}
+	var b strings.Builder
+	for _, s := range settings {
+		if s.key == "exclude_file_regexes" && len(d.excludeFileRegexes) == 0 {
+			continue
+		}
+		if value, err := FormatTOMLValue(s.value); err != nil {
+			return "", fmt.Errorf("formatting %s: %w", s.key, err)
+		} else {
+			fmt.Fprintf(&b, "%s = %s\n", s.key, value)
+		}
+	}
+	return b.String(), nil
+}
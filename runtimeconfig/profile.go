@@ -0,0 +1,88 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// checkProfiles decodes every [profile.<name>] table of the config file
+// d, so that problems are reported regardless of the profiles in use.
+func (d *tomlConfig) checkProfiles() error {
+	for _, name := range slices.Sorted(maps.Keys(d.Profile)) {
+		for _, key := range fileKeys {
+			if d.meta.IsDefined("profile", name, key) {
+				return d.errorAt("profile."+name+"."+key, fmt.Errorf("%s is not allowed in a profile", key))
+			}
+		}
+		var scratch tomlConfig
+		if err := d.meta.PrimitiveDecode(d.Profile[name], &scratch); err != nil {
+			return d.decodeError(err)
+		}
+	}
+	return nil
+}
+
+// applyProfiles overlays the settings of the [profile.<name>] tables
+// named by names onto merged, in order. A profile may be defined in any
+// of the config files of chain, which contribute to it in turn. Settings
+// of a profile replace those defined before it, except for tables such
+// as env whose entries are merged.
+func applyProfiles(merged *tomlConfig, chain []*tomlConfig, names []string) error {
+	root := chain[len(chain)-1]
+	var available []string
+	for _, f := range chain {
+		available = append(available, slices.Collect(maps.Keys(f.Profile))...)
+	}
+	slices.Sort(available)
+	available = slices.Compact(available)
+	for _, name := range names {
+		if !slices.Contains(available, name) {
+			if len(available) == 0 {
+				return root.errorAt("", fmt.Errorf("unknown profile %s, the config file has no profiles", name))
+			}
+			return root.errorAt("", fmt.Errorf(
+				"unknown profile %s%s, expected one of: %s",
+				name,
+				didYouMean(name, available),
+				strings.Join(available, ", "),
+			))
+		}
+		for _, f := range chain {
+			if p, ok := f.Profile[name]; ok {
+				// Lists set by the profile replace the current ones
+				// rather than being decoded over them.
+				for _, key := range listSettingKeys() {
+					if f.meta.IsDefined("profile", name, key) {
+						merged.resetList(key)
+					}
+				}
+				if err := f.meta.PrimitiveDecode(p, merged); err != nil {
+					return f.decodeError(err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// definition returns the config file of the chain of d defining the
+// setting key, either at the top level or in an applied profile, along
+// with the path of the definition in effect within that file.
+func (d *tomlConfig) definition(key string) (*tomlConfig, string, bool) {
+	chain := d.chain()
+	for _, name := range slices.Backward(d.profiles) {
+		for _, f := range slices.Backward(chain) {
+			if f.meta.IsDefined("profile", name, key) {
+				return f, "profile." + name + "." + key, true
+			}
+		}
+	}
+	for _, f := range slices.Backward(chain) {
+		if f.meta.IsDefined(key) {
+			return f, key, true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,41 @@
+package runtimeconfig
+
+import "fmt"
+
+// RestartStrategy determines how the server process is replaced when
+// watched files change.
+type RestartStrategy int
+
+const (
+	// StopThenStart stops the current server process before starting
+	// the new one.
+	StopThenStart RestartStrategy = iota
+	// StartThenStop starts the new server process while the current one
+	// is still running and stops the current process only after the new
+	// one is ready.
+	StartThenStop
+)
+
+func (r RestartStrategy) String() string {
+	return [...]string{"stop-then-start", "start-then-stop"}[r]
+}
+
+func (r RestartStrategy) EnumIndex() int {
+	return int(r)
+}
+
+func allRestartStrategies() []RestartStrategy {
+	return []RestartStrategy{
+		StopThenStart,
+		StartThenStop,
+	}
+}
+
+func parseRestartStrategy(s string) (RestartStrategy, error) {
+	for _, r := range allRestartStrategies() {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return StopThenStart, fmt.Errorf("restart_strategy value not supported: %s", s)
+}
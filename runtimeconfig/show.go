@@ -0,0 +1,171 @@
+package runtimeconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Settings implements Config.
+func (c *config) Settings() []Setting {
+	preambles := make([]any, 0, len(c.preambles))
+	for _, p := range c.preambles {
+		preambles = append(preambles, preambleValue(p))
+	}
+	onChange := make([]any, 0, len(c.onChangeRules))
+	for _, r := range c.onChangeRules {
+		rule := map[string]any{
+			"command":              r.Command,
+			"include_file_regexes": regexStrings(r.IncludeFileRegexes),
+			"restart":              r.Restart,
+		}
+		if len(r.ExcludeFileRegexes) > 0 {
+			rule["exclude_file_regexes"] = regexStrings(r.ExcludeFileRegexes)
+		}
+		onChange = append(onChange, rule)
+	}
+	env := map[string]string{}
+	for k, v := range c.env {
+		env[k] = v
+	}
+	values := map[string]any{
+		"include_file_regexes":  regexStrings(c.includeFileRegexes),
+		"exclude_file_regexes":  regexStrings(c.excludeFileRegexes),
+		"preamble_commands":     preambles,
+		"server_command":        c.serverCommand,
+		"quit_signal":           signalName(c.quitSignal),
+		"log_level":             c.logLevel.String(),
+		"restart_strategy":      c.restartStrategy.String(),
+		"readiness_command":     c.readinessCommand,
+		"readiness_timeout":     c.readinessTimeout.String(),
+		"env":                   env,
+		"env_file":              nonNil(c.envFiles),
+		"clear_env":             c.clearEnv,
+		"on_change":             onChange,
+		"mode":                  c.mode.String(),
+		"exec_command":          c.execCommand,
+		"preset":                c.preset.String(),
+		"ignore_directories":    nonNil(c.ignoreDirectories),
+		"watch_paths":           nonNil(c.watchPaths),
+		"watcher":               c.watcher.String(),
+		"poll_interval":         c.pollInterval.String(),
+		"poll_hash":             c.pollHash,
+		"poll_over_watch_limit": c.pollOverWatchLimit,
+	}
+	result := make([]Setting, 0, len(values))
+	for _, key := range settingKeys() {
+		result = append(result, Setting{
+			Key:    key,
+			Value:  values[key],
+			Origin: c.origin(key),
+		})
+	}
+	return result
+}
+
+// FormatTOML renders the effective settings of c as a TOML document in
+// which each setting is followed by a comment naming its origin.
+func FormatTOML(c Config) (string, error) {
+	var b strings.Builder
+	if c.ConfigFile() != "" {
+		fmt.Fprintf(&b, "# Config file: %s\n", c.ConfigFile())
+	} else {
+		b.WriteString("# Config file: none\n")
+	}
+	if len(c.BaseConfigFiles()) > 0 {
+		fmt.Fprintf(&b, "# Extends: %s\n", strings.Join(c.BaseConfigFiles(), ", "))
+	}
+	if len(c.Profiles()) > 0 {
+		fmt.Fprintf(&b, "# Profiles: %s\n", strings.Join(c.Profiles(), ", "))
+	}
+	for _, s := range c.Settings() {
+		if value, err := FormatTOMLValue(s.Value); err != nil {
+			return "", fmt.Errorf("formatting %s: %w", s.Key, err)
+		} else {
+			fmt.Fprintf(&b, "%s = %s  # %s\n", s.Key, value, s.Origin)
+		}
+	}
+	return b.String(), nil
+}
+
+// FormatJSON renders the effective settings of c as a JSON document that
+// records the origin of each setting.
+func FormatJSON(c Config) ([]byte, error) {
+	type jsonSetting struct {
+		Value    any    `json:"value"`
+		Source   string `json:"source"`
+		File     string `json:"file,omitempty"`
+		Line     int    `json:"line,omitempty"`
+		Variable string `json:"variable,omitempty"`
+	}
+	doc := struct {
+		ConfigFile      string                 `json:"config_file,omitempty"`
+		BaseConfigFiles []string               `json:"base_config_files,omitempty"`
+		Profiles        []string               `json:"profiles,omitempty"`
+		Settings        map[string]jsonSetting `json:"settings"`
+	}{
+		ConfigFile:      c.ConfigFile(),
+		BaseConfigFiles: c.BaseConfigFiles(),
+		Profiles:        c.Profiles(),
+		Settings:        map[string]jsonSetting{},
+	}
+	for _, s := range c.Settings() {
+		doc.Settings[s.Key] = jsonSetting{
+			Value:    s.Value,
+			Source:   s.Origin.Source.String(),
+			File:     s.Origin.File,
+			Line:     s.Origin.Line,
+			Variable: s.Origin.Variable,
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// preambleValue returns the resolved preamble p as a preamble_commands
+// table.
+func preambleValue(p Preamble) map[string]any {
+	result := map[string]any{
+		"name":    p.Name,
+		"command": p.Command,
+	}
+	for k, v := range map[string][]string{
+		"depends_on":           p.DependsOn,
+		"include_file_regexes": regexStrings(p.IncludeFileRegexes),
+		"exclude_file_regexes": regexStrings(p.ExcludeFileRegexes),
+		"inputs":               p.Inputs,
+		"outputs":              p.Outputs,
+	} {
+		if len(v) > 0 {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func regexStrings(regexes []regexp.Regexp) []string {
+	result := make([]string, 0, len(regexes))
+	for _, r := range regexes {
+		result = append(result, r.String())
+	}
+	return result
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func signalName(s syscall.Signal) string {
+	switch s {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	default:
+		return s.String()
+	}
+}
@@ -0,0 +1,59 @@
+package runtimeconfig
+
+import "fmt"
+
+// Source identifies where the effective value of a setting came from.
+type Source int
+
+const (
+	// SourceDefault is the built-in default value.
+	SourceDefault Source = iota
+	// SourcePreset is a default supplied by the active preset.
+	SourcePreset
+	// SourceFile is the config file.
+	SourceFile
+	// SourceEnv is an environment variable.
+	SourceEnv
+	// SourceFlag is the command line.
+	SourceFlag
+)
+
+func (r Source) String() string {
+	return [...]string{"default", "preset", "file", "env", "flag"}[r]
+}
+
+func (r Source) EnumIndex() int {
+	return int(r)
+}
+
+// Origin describes where a setting was defined. File and Line are set
+// for settings from a config file, Variable for settings from an
+// environment variable.
+type Origin struct {
+	Source   Source
+	File     string
+	Line     int
+	Variable string
+}
+
+func (o Origin) String() string {
+	if o.Source == SourceEnv {
+		return fmt.Sprintf("%s %s", o.Source, o.Variable)
+	}
+	if o.Source != SourceFile {
+		return o.Source.String()
+	}
+	if o.Line > 0 {
+		return fmt.Sprintf("%s %s:%d", o.Source, o.File, o.Line)
+	}
+	return fmt.Sprintf("%s %s", o.Source, o.File)
+}
+
+// Setting is the effective value of a config file key. Value holds
+// strings, booleans, string slices, string maps, or slices of tables
+// represented as string-keyed maps.
+type Setting struct {
+	Key    string
+	Value  any
+	Origin Origin
+}
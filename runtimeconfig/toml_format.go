@@ -0,0 +1,118 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FormatTOMLValue renders v as an inline TOML value, as written in config
+// files. Supported types are strings, booleans, numbers, slices and
+// string-keyed maps of those.
+func FormatTOMLValue(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return formatTOMLString(t)
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int:
+		return strconv.Itoa(t), nil
+	case int64:
+		return strconv.FormatInt(t, 10), nil
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64), nil
+	case []string:
+		items := make([]any, 0, len(t))
+		for _, s := range t {
+			items = append(items, s)
+		}
+		return FormatTOMLValue(items)
+	case []any:
+		items := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, err := FormatTOMLValue(item); err != nil {
+				return "", err
+			} else {
+				items = append(items, s)
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]string:
+		m := make(map[string]any, len(t))
+		for k, item := range t {
+			m[k] = item
+		}
+		return FormatTOMLValue(m)
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		items := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if key, err := formatTOMLKey(k); err != nil {
+				return "", err
+			} else if value, err := FormatTOMLValue(t[k]); err != nil {
+				return "", err
+			} else {
+				items = append(items, fmt.Sprintf("%s = %s", key, value))
+			}
+		}
+		if len(items) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported value type: %T", v)
+}
+
+func formatTOMLKey(k string) (string, error) {
+	for _, r := range k {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return formatTOMLString(k)
+		}
+	}
+	if k == "" {
+		return `""`, nil
+	}
+	return k, nil
+}
+
+// formatTOMLString renders s as a TOML basic string, which, unlike a Go
+// string literal, has no \x or \a escapes and cannot hold invalid UTF-8.
+func formatTOMLString(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("string is not valid UTF-8: %q", s)
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), nil
+}
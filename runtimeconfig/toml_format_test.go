@@ -0,0 +1,79 @@
+package runtimeconfig_test
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatTOMLValue(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		value         any
+		expected      string
+		expectedError string
+	}{
+		{
+			desc:     "plain string",
+			value:    "go build .",
+			expected: `"go build ."`,
+		},
+		{
+			desc:     "escaped characters",
+			value:    "say \"café\"\\\t\n\a\x7f",
+			expected: `"say \"café\"\\\t\n\u0007\u007F"`,
+		},
+		{
+			desc:     "list",
+			value:    []string{`\.go$`, "a\"b"},
+			expected: `["\\.go$", "a\"b"]`,
+		},
+		{
+			desc:     "table",
+			value:    map[string]any{"PORT": "8080", "my key": true, "n": int64(3)},
+			expected: `{ PORT = "8080", "my key" = true, n = 3 }`,
+		},
+		{
+			desc:          "invalid UTF-8",
+			value:         []string{"ok", "\xff"},
+			expectedError: `string is not valid UTF-8: "\xff"`,
+		},
+		{
+			desc:          "unsupported type",
+			value:         []any{struct{}{}},
+			expectedError: "unsupported value type: struct {}",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			result, err := runtimeconfig.FormatTOMLValue(tc.value)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+
+			// The result decodes to the original value.
+			var doc map[string]any
+			if _, err := toml.Decode("v = "+result, &doc); assert.NoError(t, err) {
+				assert.EqualValues(t, tc.value, normalize(doc["v"]))
+			}
+		})
+	}
+}
+
+// normalize converts the decoded TOML value v to the types passed to
+// FormatTOMLValue in the test cases.
+func normalize(v any) any {
+	if items, ok := v.([]any); ok {
+		result := make([]string, 0, len(items))
+		for _, item := range items {
+			result = append(result, item.(string))
+		}
+		return result
+	}
+	return v
+}
@@ -0,0 +1,54 @@
+package runtimeconfig
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultPollInterval = time.Second
+
+// WatcherBackend determines how changes to the watched directories are
+// detected.
+type WatcherBackend int
+
+const (
+	// AutoWatcher uses fsnotify and falls back to polling when fsnotify
+	// fails or the working directory is on a filesystem that does not
+	// deliver its events.
+	AutoWatcher WatcherBackend = iota
+	// FSNotifyWatcher uses the notifications of the operating system,
+	// such as inotify on Linux.
+	FSNotifyWatcher
+	// PollWatcher scans the watched directories periodically.
+	PollWatcher
+	// FanotifyWatcher marks whole filesystems with fanotify on Linux
+	// instead of registering every watched directory, and falls back to
+	// fsnotify when fanotify is unavailable.
+	FanotifyWatcher
+)
+
+func (w WatcherBackend) String() string {
+	return [...]string{"auto", "fsnotify", "poll", "fanotify"}[w]
+}
+
+func (w WatcherBackend) EnumIndex() int {
+	return int(w)
+}
+
+func allWatcherBackends() []WatcherBackend {
+	return []WatcherBackend{
+		AutoWatcher,
+		FSNotifyWatcher,
+		PollWatcher,
+		FanotifyWatcher,
+	}
+}
+
+func parseWatcherBackend(s string) (WatcherBackend, error) {
+	for _, w := range allWatcherBackends() {
+		if w.String() == s {
+			return w, nil
+		}
+	}
+	return AutoWatcher, fmt.Errorf("watcher value not supported: %s", s)
+}
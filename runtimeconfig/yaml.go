@@ -0,0 +1,105 @@
+package runtimeconfig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToTOML converts the YAML config file content at path to an
+// equivalent TOML document, so that YAML files share the schema and
+// checks of TOML files. JSON documents are valid YAML and are converted
+// the same way. It also returns the line on which each key is defined.
+func yamlToTOML(path string, content []byte) (string, map[string]int, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return "", nil, &ConfigError{Problems: []*Problem{{File: path, Err: err}}}
+	}
+	lines := map[string]int{}
+	if len(root.Content) == 0 {
+		// Empty document.
+		return "", lines, nil
+	}
+	if root.Content[0].Kind != yaml.MappingNode {
+		return "", nil, &ConfigError{Problems: []*Problem{
+			{File: path, Line: root.Content[0].Line, Err: errors.New("config file must contain a mapping of settings")},
+		}}
+	}
+	yamlKeyLines(root.Content[0], "", lines)
+	var values map[string]any
+	if err := root.Content[0].Decode(&values); err != nil {
+		return "", nil, &ConfigError{Problems: []*Problem{{File: path, Err: err}}}
+	}
+	// Keep the order of the file so problems are reported in that order.
+	mapping := root.Content[0].Content
+	var b strings.Builder
+	for i := 0; i < len(mapping); i += 2 {
+		k := mapping[i].Value
+		problem := func(err error) error {
+			return &ConfigError{Problems: []*Problem{
+				{File: path, Line: keyLine(lines, k), Err: err},
+			}}
+		}
+		if err := checkTOMLValue(k, values[k]); err != nil {
+			return "", nil, problem(err)
+		} else if key, err := formatTOMLKey(k); err != nil {
+			return "", nil, problem(err)
+		} else if value, err := FormatTOMLValue(values[k]); err != nil {
+			return "", nil, problem(fmt.Errorf("%s: %w", k, err))
+		} else {
+			fmt.Fprintf(&b, "%s = %s\n", key, value)
+		}
+	}
+	return b.String(), lines, nil
+}
+
+// yamlKeyLines records in lines the line of each key of the node n,
+// prefixing keys with the dotted path prefix.
+func yamlKeyLines(n *yaml.Node, prefix string, lines map[string]int) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if _, ok := lines[key]; !ok {
+				lines[key] = n.Content[i].Line
+			}
+			yamlKeyLines(n.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			yamlKeyLines(c, prefix, lines)
+		}
+	}
+}
+
+// checkTOMLValue reports whether v, the value of key, can be represented
+// in TOML.
+func checkTOMLValue(key string, v any) error {
+	switch t := v.(type) {
+	case string, bool, int, int64, float64:
+		return nil
+	case []any:
+		for _, item := range t {
+			if err := checkTOMLValue(key, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		for k, item := range t {
+			if err := checkTOMLValue(key+"."+k, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("%s has no value", key)
+	default:
+		return fmt.Errorf("%s has a value of unsupported type %T", key, v)
+	}
+}
@@ -0,0 +1,273 @@
+package scaffold
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+// Project describes what Detect found in a directory.
+type Project struct {
+	Preset           runtimeconfig.Preset
+	PreambleCommands []string
+	ServerCommand    string
+	// Findings are human-readable notes about what was detected, written
+	// as comments in the generated config file.
+	Findings []string
+}
+
+var makeTargetPattern = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_.-]*)\s*:([^=]|$)`)
+
+// Detect inspects dir for well-known project files and proposes settings.
+func Detect(dir string) (Project, error) {
+	var p Project
+	var err error
+	switch {
+	case fileExists(dir, "go.mod"):
+		err = detectGo(dir, &p)
+	case fileExists(dir, "package.json"):
+		err = detectNode(dir, &p)
+	case fileExists(dir, "Cargo.toml"):
+		err = detectRust(dir, &p)
+	case fileExists(dir, "pyproject.toml"), fileExists(dir, "requirements.txt"):
+		detectPython(dir, &p)
+	}
+	if err != nil {
+		return p, err
+	}
+	if fileExists(dir, "Makefile") {
+		if err := detectMake(dir, &p); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func detectGo(dir string, p *Project) error {
+	p.Preset = runtimeconfig.GoPreset
+	modulePath, err := goModulePath(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return err
+	}
+	p.Findings = append(p.Findings, "Go module "+modulePath)
+	mains, err := mainPackageDirs(dir)
+	if err != nil {
+		return err
+	}
+	if len(mains) == 0 {
+		p.Findings = append(p.Findings, "no main packages")
+		return nil
+	}
+	for _, m := range mains {
+		if m == "." {
+			p.Findings = append(p.Findings, "main package at the module root")
+		} else {
+			p.Findings = append(p.Findings, "main package ./"+m)
+		}
+	}
+	// Prefer a main package at the module root, then the first one found.
+	mainDir := mains[0]
+	binary := path.Base(modulePath)
+	if slices.Contains(mains, ".") {
+		mainDir = "."
+	} else {
+		binary = path.Base(mainDir)
+	}
+	target := "."
+	if mainDir != "." {
+		target = "./" + mainDir
+	}
+	p.PreambleCommands = []string{"go build -o " + binary + " " + target}
+	p.ServerCommand = "./" + binary
+	return nil
+}
+
+func goModulePath(goMod string) (string, error) {
+	f, err := os.Open(goMod)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("go.mod has no module directive")
+}
+
+// mainPackageDirs returns the slash-separated directories, relative to
+// dir, that contain a non-test Go file declaring package main.
+func mainPackageDirs(dir string) ([]string, error) {
+	var result []string
+	skip := []string{".git", "vendor", "testdata", "node_modules"}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && (slices.Contains(skip, d.Name()) || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if slices.Contains(result, rel) {
+			return nil
+		}
+		if isMain, err := declaresPackageMain(p); err != nil {
+			return err
+		} else if isMain {
+			result = append(result, rel)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func declaresPackageMain(goFile string) (bool, error) {
+	f, err := os.Open(goFile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "package" {
+			return fields[1] == "main", nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func detectNode(dir string, p *Project) error {
+	p.Preset = runtimeconfig.NodePreset
+	var pkg struct {
+		Name    string            `json:"name"`
+		Scripts map[string]string `json:"scripts"`
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "package.json")); err != nil {
+		return err
+	} else if err := json.Unmarshal(b, &pkg); err != nil {
+		return err
+	}
+	p.Findings = append(p.Findings, "Node package "+pkg.Name)
+	scripts := make([]string, 0, len(pkg.Scripts))
+	for s := range pkg.Scripts {
+		scripts = append(scripts, s)
+	}
+	slices.Sort(scripts)
+	if len(scripts) > 0 {
+		p.Findings = append(p.Findings, "npm scripts: "+strings.Join(scripts, ", "))
+	}
+	if _, found := pkg.Scripts["build"]; found {
+		p.PreambleCommands = []string{"npm run build"}
+	}
+	if _, found := pkg.Scripts["start"]; found {
+		p.ServerCommand = "npm start"
+	} else if fileExists(dir, "server.js") {
+		p.ServerCommand = "node server.js"
+	} else if fileExists(dir, "index.js") {
+		p.ServerCommand = "node index.js"
+	}
+	return nil
+}
+
+func detectRust(dir string, p *Project) error {
+	p.Preset = runtimeconfig.RustPreset
+	var cargo struct {
+		Package struct {
+			Name string `toml:"name"`
+		} `toml:"package"`
+	}
+	if _, err := toml.DecodeFile(filepath.Join(dir, "Cargo.toml"), &cargo); err != nil {
+		return err
+	}
+	if cargo.Package.Name == "" {
+		p.Findings = append(p.Findings, "Cargo workspace")
+		return nil
+	}
+	p.Findings = append(p.Findings, "Cargo package "+cargo.Package.Name)
+	p.ServerCommand = "./target/debug/" + cargo.Package.Name
+	return nil
+}
+
+func detectPython(dir string, p *Project) {
+	p.Preset = runtimeconfig.PythonPreset
+	p.Findings = append(p.Findings, "Python project")
+	for _, candidate := range []struct {
+		file    string
+		command string
+	}{
+		{"manage.py", "python manage.py runserver --noreload"},
+		{"app.py", "python app.py"},
+		{"main.py", "python main.py"},
+	} {
+		if fileExists(dir, candidate.file) {
+			p.ServerCommand = candidate.command
+			return
+		}
+	}
+}
+
+// detectMake uses Makefile targets for the commands that were not
+// detected otherwise.
+func detectMake(dir string, p *Project) error {
+	f, err := os.Open(filepath.Join(dir, "Makefile"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var targets []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := makeTargetPattern.FindStringSubmatch(scanner.Text()); m != nil && !slices.Contains(targets, m[1]) {
+			targets = append(targets, m[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	p.Findings = append(p.Findings, "Makefile targets: "+strings.Join(targets, ", "))
+	if len(p.PreambleCommands) == 0 && slices.Contains(targets, "build") {
+		p.PreambleCommands = []string{"make build"}
+	}
+	if p.ServerCommand == "" {
+		for _, t := range []string{"run", "serve", "dev", "start"} {
+			if slices.Contains(targets, t) {
+				p.ServerCommand = "make " + t
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func fileExists(dir, name string) bool {
+	fi, err := os.Stat(filepath.Join(dir, name))
+	return err == nil && !fi.IsDir()
+}
@@ -0,0 +1,74 @@
+package scaffold
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+// Render returns a commented config file for p.
+func Render(p Project) (string, error) {
+	var b strings.Builder
+	b.WriteString("# go-procrotator configuration generated by \"go-procrotator init\".\n")
+	if len(p.Findings) > 0 {
+		b.WriteString("#\n# Detected:\n")
+		for _, f := range p.Findings {
+			fmt.Fprintf(&b, "#   - %s\n", f)
+		}
+	}
+	b.WriteString("\n")
+
+	if p.Preset != runtimeconfig.NoPreset {
+		description, err := p.Preset.Describe()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("# The preset supplies defaults for any of the following settings that\n")
+		b.WriteString("# this file does not set:\n#\n")
+		for _, line := range strings.Split(strings.TrimSpace(description), "\n") {
+			fmt.Fprintf(&b, "#   %s\n", line)
+		}
+		if err := writeSetting(&b, "preset", p.Preset.String()); err != nil {
+			return "", err
+		}
+		b.WriteString("\n")
+	} else {
+		// An empty list would be rejected, so leave the setting for the
+		// user to fill in.
+		b.WriteString("# Regular expressions matching the files to watch.\n")
+		b.WriteString("# TODO: set the files to watch, for example:\n")
+		b.WriteString(`# include_file_regexes = ["\\.c$", "\\.h$"]` + "\n\n")
+	}
+
+	b.WriteString("# Commands run before the server command on every restart.\n")
+	if len(p.PreambleCommands) > 0 {
+		if err := writeSetting(&b, "preamble_commands", p.PreambleCommands); err != nil {
+			return "", err
+		}
+		b.WriteString("\n")
+	} else {
+		b.WriteString("# preamble_commands = []\n\n")
+	}
+
+	b.WriteString("# The command starting the server process.\n")
+	if p.ServerCommand != "" {
+		if err := writeSetting(&b, "server_command", p.ServerCommand); err != nil {
+			return "", err
+		}
+	} else {
+		b.WriteString("# TODO: set the command that starts the server, for example:\n")
+		b.WriteString("# server_command = \"./server\"\n")
+	}
+	return b.String(), nil
+}
+
+// writeSetting writes the setting key with the value v to b.
+func writeSetting(b *strings.Builder, key string, v any) error {
+	if value, err := runtimeconfig.FormatTOMLValue(v); err != nil {
+		return fmt.Errorf("formatting %s: %w", key, err)
+	} else {
+		fmt.Fprintf(b, "%s = %s\n", key, value)
+		return nil
+	}
+}
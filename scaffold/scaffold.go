@@ -0,0 +1,50 @@
+// Package scaffold generates a config file for an existing project.
+package scaffold
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+)
+
+// FileName is the name of the generated config file.
+const FileName = "procrotator.toml"
+
+// ErrConfigExists is returned by Write when the directory already has a
+// config file and overwriting was not requested.
+var ErrConfigExists = errors.New("config file already exists")
+
+// Write detects the project in dir and writes a config file for it,
+// returning the path written and the detected project. An existing
+// config file is only overwritten when force is set.
+func Write(dir string, force bool) (string, Project, error) {
+	target := filepath.Join(dir, FileName)
+	for _, name := range runtimeconfig.ConfigFileNames() {
+		existing := filepath.Join(dir, name)
+		if _, err := os.Stat(existing); err == nil {
+			if !force {
+				return existing, Project{}, fmt.Errorf("%w: %s (use -force to overwrite)", ErrConfigExists, existing)
+			} else if filepath.Ext(existing) != ".toml" {
+				// Writing a TOML file next to it would leave two config files.
+				return existing, Project{}, fmt.Errorf("%w: %s is not a TOML file, remove it first", ErrConfigExists, existing)
+			}
+			target = existing
+			break
+		}
+	}
+	p, err := Detect(dir)
+	if err != nil {
+		return "", p, fmt.Errorf("inspecting %s: %w", dir, err)
+	}
+	content, err := Render(p)
+	if err != nil {
+		return "", p, err
+	}
+	if err := os.WriteFile(target, []byte(content), 0666); err != nil {
+		return target, p, err
+	}
+	return target, p, nil
+}
@@ -0,0 +1,154 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/scaffold"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(d string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(d, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0666); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	type testConfig struct {
+		desc     string
+		files    map[string]string
+		expected scaffold.Project
+	}
+	testConfigs := []testConfig{
+		{
+			desc: "go module with cmd directory",
+			files: map[string]string{
+				"go.mod":                "module example.com/shop\n\ngo 1.23\n",
+				"cmd/shopd/main.go":     "// Command shopd.\npackage main\n",
+				"internal/db/db.go":     "package db\n",
+				"internal/db/x_test.go": "package main\n",
+			},
+			expected: scaffold.Project{
+				Preset:           runtimeconfig.GoPreset,
+				PreambleCommands: []string{"go build -o shopd ./cmd/shopd"},
+				ServerCommand:    "./shopd",
+				Findings:         []string{"Go module example.com/shop", "main package ./cmd/shopd"},
+			},
+		},
+		{
+			desc: "node package",
+			files: map[string]string{
+				"package.json": `{"name": "web", "scripts": {"build": "tsc", "start": "node dist/index.js"}}`,
+			},
+			expected: scaffold.Project{
+				Preset:           runtimeconfig.NodePreset,
+				PreambleCommands: []string{"npm run build"},
+				ServerCommand:    "npm start",
+				Findings:         []string{"Node package web", "npm scripts: build, start"},
+			},
+		},
+		{
+			desc: "makefile only",
+			files: map[string]string{
+				"Makefile": "BIN := app\n\n.PHONY: build\nbuild:\n\tcc -o app app.c\n\nrun: build\n\t./app\n",
+			},
+			expected: scaffold.Project{
+				PreambleCommands: []string{"make build"},
+				ServerCommand:    "make run",
+				Findings:         []string{"Makefile targets: build, run"},
+			},
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(cfg.desc, func(t *testing.T) {
+			d := t.TempDir()
+			writeFiles(d, cfg.files)
+			p, err := scaffold.Detect(d)
+			assert.NoError(t, err)
+			assert.Equal(t, cfg.expected, p)
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	d := t.TempDir()
+	writeFiles(d, map[string]string{
+		"go.mod":  "module example.com/app\n",
+		"main.go": "package main\n",
+	})
+	path, _, err := scaffold.Write(d, false)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(d, "procrotator.toml"), path)
+
+	// The generated file is a valid configuration.
+	c, err := runtimeconfig.Build([]string{"-d", d})
+	assert.NoError(t, err)
+	assert.Equal(t, runtimeconfig.GoPreset, c.Preset())
+	assert.Equal(t, "./app", c.ServerCommand())
+	assert.Equal(t, []string{"go build -o app ."}, c.PreambleCommands())
+
+	_, _, err = scaffold.Write(d, false)
+	assert.ErrorIs(t, err, scaffold.ErrConfigExists)
+	_, _, err = scaffold.Write(d, true)
+	assert.NoError(t, err)
+}
+
+func TestRender(t *testing.T) {
+	type testConfig struct {
+		desc        string
+		project     scaffold.Project
+		contains    []string
+		notContains []string
+	}
+	testConfigs := []testConfig{
+		{
+			desc: "preset and server command",
+			project: scaffold.Project{
+				Preset:           runtimeconfig.NodePreset,
+				PreambleCommands: []string{"npm run build"},
+				ServerCommand:    "npm start",
+			},
+			contains: []string{
+				"\npreset = \"node\"\n",
+				"\npreamble_commands = [\"npm run build\"]\n",
+				"\nserver_command = \"npm start\"\n",
+			},
+			notContains: []string{"TODO"},
+		},
+		{
+			desc:    "nothing detected",
+			project: scaffold.Project{},
+			contains: []string{
+				"\n# include_file_regexes = [\"\\\\.c$\", \"\\\\.h$\"]\n",
+				"\n# preamble_commands = []\n",
+				"\n# server_command = \"./server\"\n",
+			},
+			notContains: []string{
+				"\ninclude_file_regexes",
+				"\nserver_command",
+				"\npreset",
+			},
+		},
+	}
+	for _, cfg := range testConfigs {
+		t.Run(cfg.desc, func(t *testing.T) {
+			content, err := scaffold.Render(cfg.project)
+			assert.NoError(t, err)
+			for _, s := range cfg.contains {
+				assert.Contains(t, content, s)
+			}
+			for _, s := range cfg.notContains {
+				assert.NotContains(t, content, s)
+			}
+		})
+	}
+}
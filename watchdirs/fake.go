@@ -0,0 +1,100 @@
+package watchdirs
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// FakeWatcher is an in-memory Watcher for tests. Instead of changes of
+// the filesystem, it reports the events and errors passed to Send and
+// SendError, which return once they have been received, so that tests
+// need not wait for the filesystem.
+type FakeWatcher struct {
+	events    chan WatcherEvent
+	errors    chan error
+	closeOnce sync.Once
+	mu        sync.Mutex
+	dirs      []string
+	limit     int
+}
+
+// NewFakeWatcher returns a FakeWatcher watching no directories.
+func NewFakeWatcher() *FakeWatcher {
+	return &FakeWatcher{
+		events: make(chan WatcherEvent),
+		errors: make(chan error),
+	}
+}
+
+// Add implements Watcher.
+func (f *FakeWatcher) Add(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if slices.Contains(f.dirs, name) {
+		return nil
+	} else if f.limit > 0 && len(f.dirs) >= f.limit {
+		return fmt.Errorf("watching %s: %w", name, syscall.ENOSPC)
+	}
+	f.dirs = append(f.dirs, name)
+	return nil
+}
+
+// SetWatchLimit makes Add fail like inotify does once n directories are
+// watched. A limit of 0 removes the limit.
+func (f *FakeWatcher) SetWatchLimit(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.limit = n
+}
+
+// Remove implements Watcher.
+func (f *FakeWatcher) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i := slices.Index(f.dirs, name); i < 0 {
+		return fmt.Errorf("%s is not watched", name)
+	} else {
+		f.dirs = slices.Delete(f.dirs, i, i+1)
+		return nil
+	}
+}
+
+// Dirs returns the watched directories, in the order they were added.
+func (f *FakeWatcher) Dirs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.dirs)
+}
+
+// Events implements Watcher.
+func (f *FakeWatcher) Events() <-chan WatcherEvent {
+	return f.events
+}
+
+// Errors implements Watcher.
+func (f *FakeWatcher) Errors() <-chan error {
+	return f.errors
+}
+
+// Close implements Watcher. Send and SendError must not be called
+// afterwards.
+func (f *FakeWatcher) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.events)
+		close(f.errors)
+	})
+	return nil
+}
+
+// Send reports the operations ops on the file path, blocking until the
+// event has been received.
+func (f *FakeWatcher) Send(path string, ops ...WatcherEventOp) {
+	f.events <- WatcherEvent{Path: path, Ops: ops}
+}
+
+// SendError reports err, blocking until it has been received.
+func (f *FakeWatcher) SendError(err error) {
+	f.errors <- err
+}
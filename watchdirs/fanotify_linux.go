@@ -0,0 +1,275 @@
+package watchdirs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// fanotifyMask selects the events reported for the marked filesystems.
+// Moves are reported like fsnotify does, as a rename of the old name and
+// a creation of the new one.
+const fanotifyMask = unix.FAN_CREATE |
+	unix.FAN_DELETE |
+	unix.FAN_MODIFY |
+	unix.FAN_MOVED_FROM |
+	unix.FAN_MOVED_TO |
+	unix.FAN_ATTRIB |
+	unix.FAN_ONDIR
+
+type (
+	// fanotifyWatcher marks the filesystems of the watched directories
+	// with fanotify, so that directories are watched without registering
+	// each of them with the kernel. Events report the handle of the
+	// directory containing the changed entry, which is looked up among
+	// the handles of the watched directories to drop the changes made
+	// elsewhere on the filesystems.
+	//
+	// Like with inotify, directories created after they were added are
+	// not watched: their creation is reported on the errors channel, and
+	// the changes within them are dropped.
+	fanotifyWatcher struct {
+		fd        int
+		file      *os.File
+		events    chan WatcherEvent
+		errors    chan error
+		quit      chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+		mu        sync.Mutex
+		marked    map[unix.Fsid]bool
+		dirs      map[fileHandle]string
+		handles   map[string]fileHandle
+	}
+	// fileHandle identifies a directory across the marked filesystems.
+	fileHandle struct {
+		fsid   unix.Fsid
+		typ    int32
+		handle string
+	}
+)
+
+// NewFanotifyWatcher returns a Watcher relying on fanotify, watching no
+// directories. It requires Linux 5.9 or later and the CAP_SYS_ADMIN
+// capability, and fails on filesystems that cannot report file handles.
+func NewFanotifyWatcher() (Watcher, error) {
+	fd, err := unix.FanotifyInit(
+		unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+		unix.O_RDONLY|unix.O_CLOEXEC,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("initializing fanotify: %w", err)
+	}
+	f := &fanotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "fanotify"),
+		events:  make(chan WatcherEvent),
+		errors:  make(chan error),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		marked:  map[unix.Fsid]bool{},
+		dirs:    map[fileHandle]string{},
+		handles: map[string]fileHandle{},
+	}
+	go f.run()
+	return f, nil
+}
+
+// Add implements Watcher. The filesystem of the directory name is marked
+// the first time one of its directories is added.
+func (f *fanotifyWatcher) Add(name string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(name, &st); err != nil {
+		return &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	h, _, err := unix.NameToHandleAt(unix.AT_FDCWD, name, 0)
+	if err != nil {
+		return &os.PathError{Op: "name_to_handle_at", Path: name, Err: err}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.marked[st.Fsid] {
+		if err := unix.FanotifyMark(f.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, name); err != nil {
+			return fmt.Errorf("marking the filesystem of %s: %w", name, err)
+		}
+		f.marked[st.Fsid] = true
+	}
+	key := fileHandle{fsid: st.Fsid, typ: h.Type(), handle: string(h.Bytes())}
+	f.dirs[key] = name
+	f.handles[name] = key
+	return nil
+}
+
+// Remove implements Watcher. Filesystems stay marked, and their events
+// are dropped unless they concern other watched directories.
+func (f *fanotifyWatcher) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if key, ok := f.handles[name]; !ok {
+		return fmt.Errorf("%s is not watched", name)
+	} else {
+		delete(f.handles, name)
+		delete(f.dirs, key)
+		return nil
+	}
+}
+
+// Events implements Watcher.
+func (f *fanotifyWatcher) Events() <-chan WatcherEvent {
+	return f.events
+}
+
+// Errors implements Watcher.
+func (f *fanotifyWatcher) Errors() <-chan error {
+	return f.errors
+}
+
+// Close implements Watcher.
+func (f *fanotifyWatcher) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		close(f.quit)
+		err = f.file.Close()
+		<-f.done
+	})
+	return err
+}
+
+func (f *fanotifyWatcher) run() {
+	defer func() {
+		close(f.events)
+		close(f.errors)
+		close(f.done)
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) {
+			return
+		} else if err != nil {
+			select {
+			case f.errors <- fmt.Errorf("reading fanotify events: %w", err):
+			case <-f.quit:
+			}
+			return
+		}
+		events, errs := f.parse(buf[:n])
+		for _, ev := range events {
+			select {
+			case f.events <- ev:
+			case <-f.quit:
+				return
+			}
+		}
+		for _, err := range errs {
+			select {
+			case f.errors <- err:
+			case <-f.quit:
+				return
+			}
+		}
+	}
+}
+
+// parse returns the events of the watched directories among the
+// fanotify events read into b.
+func (f *fanotifyWatcher) parse(b []byte) ([]WatcherEvent, []error) {
+	var (
+		events []WatcherEvent
+		errs   []error
+	)
+	for len(b) >= unix.FAN_EVENT_METADATA_LEN {
+		eventLen := binary.NativeEndian.Uint32(b[0:])
+		metadataLen := binary.NativeEndian.Uint16(b[6:])
+		mask := binary.NativeEndian.Uint64(b[8:])
+		if b[4] != unix.FANOTIFY_METADATA_VERSION {
+			errs = append(errs, fmt.Errorf("unexpected fanotify metadata version: %d", b[4]))
+			break
+		} else if eventLen < uint32(metadataLen) || int(eventLen) > len(b) {
+			errs = append(errs, errors.New("truncated fanotify event"))
+			break
+		}
+		if mask&unix.FAN_Q_OVERFLOW != 0 {
+			errs = append(errs, errors.New("fanotify queue overflowed, changes were missed"))
+		} else if ev, ok := f.event(mask, b[metadataLen:eventLen]); ok {
+			events = append(events, ev)
+			if mask&unix.FAN_ONDIR != 0 && mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+				errs = append(errs, fmt.Errorf("directory %s is not watched, changes within it are ignored", ev.Path))
+			}
+		}
+		b = b[eventLen:]
+	}
+	return events, errs
+}
+
+// event returns the event described by mask and the information records
+// info, when it concerns an entry of a watched directory.
+func (f *fanotifyWatcher) event(mask uint64, info []byte) (WatcherEvent, bool) {
+	for len(info) >= 4 {
+		infoType := info[0]
+		infoLen := int(binary.NativeEndian.Uint16(info[2:]))
+		if infoLen < 4 || infoLen > len(info) {
+			break
+		}
+		if infoType == unix.FAN_EVENT_INFO_TYPE_DFID_NAME {
+			if dir, name, ok := f.lookup(info[4:infoLen]); ok {
+				return WatcherEvent{Path: filepath.Join(dir, name), Ops: fanotifyOps(mask)}, true
+			}
+		}
+		info = info[infoLen:]
+	}
+	return WatcherEvent{}, false
+}
+
+// lookup returns the watched directory identified by the record rec,
+// holding the filesystem ID, the file handle of the directory and the
+// name of the changed entry.
+func (f *fanotifyWatcher) lookup(rec []byte) (string, string, bool) {
+	if len(rec) < 16 {
+		return "", "", false
+	}
+	fsid := unix.Fsid{Val: [2]int32{
+		int32(binary.NativeEndian.Uint32(rec[0:])),
+		int32(binary.NativeEndian.Uint32(rec[4:])),
+	}}
+	handleLen := int(binary.NativeEndian.Uint32(rec[8:]))
+	handleType := int32(binary.NativeEndian.Uint32(rec[12:]))
+	if 16+handleLen > len(rec) {
+		return "", "", false
+	}
+	name, _, _ := bytes.Cut(rec[16+handleLen:], []byte{0})
+	if len(name) == 0 || string(name) == "." {
+		// Changes to the directory itself.
+		return "", "", false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dir, ok := f.dirs[fileHandle{fsid: fsid, typ: handleType, handle: string(rec[16 : 16+handleLen])}]
+	return dir, string(name), ok
+}
+
+func fanotifyOps(mask uint64) []WatcherEventOp {
+	var ops []WatcherEventOp
+	if mask&unix.FAN_ATTRIB != 0 {
+		ops = append(ops, CHMOD)
+	}
+	if mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+		ops = append(ops, CREATE)
+	}
+	if mask&unix.FAN_DELETE != 0 {
+		ops = append(ops, REMOVE)
+	}
+	if mask&unix.FAN_MOVED_FROM != 0 {
+		ops = append(ops, RENAME)
+	}
+	if mask&unix.FAN_MODIFY != 0 {
+		ops = append(ops, WRITE)
+	}
+	return ops
+}
@@ -0,0 +1,90 @@
+package watchdirs_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, err := watchdirs.NewFanotifyWatcher()
+	if err != nil {
+		t.Skipf("fanotify is unavailable: %s", err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Skipf("fanotify is unavailable: %s", err)
+	}
+
+	// Since the events of a file may be merged or reported more than
+	// once, the events of paths already expected are skipped, while those
+	// of other paths fail the test.
+	expected := map[string]bool{}
+	assertOp := func(path string, op watchdirs.WatcherEventOp) {
+		t.Helper()
+		expected[path] = true
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case ev := <-w.Events():
+				if ev.Path == path && slices.Contains(ev.Ops, op) {
+					return
+				} else if !expected[ev.Path] {
+					assert.FailNow(t, "Unexpected event", "%v while expecting %s of %s", ev, op, path)
+				}
+			case <-timeout:
+				assert.FailNow(t, "No event", "expected %s of %s", op, path)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "main.go")
+	writeFile(t, path, "package main")
+	assertOp(path, watchdirs.CREATE)
+	writeFile(t, path, "package main // changed")
+	assertOp(path, watchdirs.WRITE)
+	assert.NoError(t, os.Chmod(path, 0600))
+	assertOp(path, watchdirs.CHMOD)
+	renamed := filepath.Join(dir, "renamed.go")
+	assert.NoError(t, os.Rename(path, renamed))
+	assertOp(path, watchdirs.RENAME)
+	assertOp(renamed, watchdirs.CREATE)
+	assert.NoError(t, os.Remove(renamed))
+	assertOp(renamed, watchdirs.REMOVE)
+
+	// Subdirectories are not watched unless added, even though their
+	// filesystem is marked, which is reported when they are created.
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0777))
+	assertOp(sub, watchdirs.CREATE)
+	select {
+	case err := <-w.Errors():
+		assert.EqualError(t, err, "directory "+sub+" is not watched, changes within it are ignored")
+	case <-time.After(time.Second):
+		assert.FailNow(t, "No error", "expected the report of %s", sub)
+	}
+	writeFile(t, filepath.Join(sub, "a.go"), "package sub")
+	marker := filepath.Join(dir, "marker")
+	writeFile(t, marker, "")
+	assertOp(marker, watchdirs.CREATE)
+	assert.NoError(t, w.Add(sub))
+	writeFile(t, filepath.Join(sub, "b.go"), "package sub")
+	assertOp(filepath.Join(sub, "b.go"), watchdirs.CREATE)
+
+	// Removed directories are no longer reported.
+	assert.NoError(t, w.Remove(sub))
+	assert.Error(t, w.Remove(sub))
+	writeFile(t, filepath.Join(sub, "c.go"), "package sub")
+	assert.NoError(t, os.Remove(marker))
+	assertOp(marker, watchdirs.REMOVE)
+
+	assert.NoError(t, w.Close())
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watchdirs
+
+import "errors"
+
+// NewFanotifyWatcher fails since fanotify is only available on Linux.
+func NewFanotifyWatcher() (Watcher, error) {
+	return nil, errors.New("fanotify is only available on Linux")
+}
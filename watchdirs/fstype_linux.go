@@ -0,0 +1,26 @@
+package watchdirs
+
+import "syscall"
+
+// Magic numbers of the filesystems on which inotify does not report
+// changes made elsewhere, from statfs(2).
+var pollingFilesystems = map[uint32]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+}
+
+// PollingFilesystem returns the name of the filesystem of path when the
+// notifications of the operating system are unreliable on it, such as on
+// network filesystems, FUSE and the 9p shares of virtual machines.
+func PollingFilesystem(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	name, ok := pollingFilesystems[uint32(st.Type)]
+	return name, ok
+}
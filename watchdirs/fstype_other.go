@@ -0,0 +1,10 @@
+//go:build !linux
+
+package watchdirs
+
+// PollingFilesystem returns the name of the filesystem of path when the
+// notifications of the operating system are unreliable on it. Only
+// Linux filesystems are recognized.
+func PollingFilesystem(path string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,96 @@
+package watchdirs
+
+import (
+	"cmp"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxUserWatchesPath holds the number of inotify watches each user may
+// register on Linux.
+const maxUserWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+// Subtree is a directory along with the number of directories of its
+// tree, itself included.
+type Subtree struct {
+	Path string
+	Dirs int
+}
+
+// IsWatchLimitError reports whether err results from reaching the
+// inotify watch limit.
+func IsWatchLimitError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// MaxUserWatches returns the number of inotify watches each user may
+// register.
+func MaxUserWatches() (int, error) {
+	b, err := os.ReadFile(maxUserWatchesPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// LargestSubtrees returns up to n of the subtrees holding the most of
+// dirs, largest first. The subtrees are found below the directories of
+// dirs whose parents are not in dirs, descending into any subtree most
+// of whose directories are in one of its own subtrees, so that a
+// dependency directory nested in a small project directory is reported
+// rather than the project directory.
+func LargestSubtrees(dirs []string, n int) []Subtree {
+	counts := map[string]int{}
+	children := map[string][]string{}
+	var roots []string
+	for _, d := range dirs {
+		counts[d] = 0
+	}
+	for d := range counts {
+		parent := filepath.Dir(d)
+		if _, ok := counts[parent]; ok && parent != d {
+			children[parent] = append(children[parent], d)
+		} else {
+			roots = append(roots, d)
+		}
+	}
+	var count func(d string) int
+	count = func(d string) int {
+		result := 1
+		for _, c := range children[d] {
+			result += count(c)
+		}
+		counts[d] = result
+		return result
+	}
+	for _, r := range roots {
+		count(r)
+	}
+	var result []Subtree
+	for _, r := range roots {
+		for _, c := range children[r] {
+			for {
+				largest := slices.MaxFunc(append([]string{""}, children[c]...), func(a, b string) int {
+					return cmp.Compare(counts[a], counts[b])
+				})
+				if largest == "" || counts[largest]*2 <= counts[c] {
+					break
+				}
+				c = largest
+			}
+			result = append(result, Subtree{Path: c, Dirs: counts[c]})
+		}
+	}
+	slices.SortFunc(result, func(a, b Subtree) int {
+		return cmp.Or(cmp.Compare(b.Dirs, a.Dirs), cmp.Compare(a.Path, b.Path))
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package watchdirs_test
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsWatchLimitError(t *testing.T) {
+	assert.True(t, watchdirs.IsWatchLimitError(fmt.Errorf("watching /app: %w", syscall.ENOSPC)))
+	assert.False(t, watchdirs.IsWatchLimitError(os.ErrNotExist))
+	assert.False(t, watchdirs.IsWatchLimitError(nil))
+}
+
+func TestLargestSubtrees(t *testing.T) {
+	dirs := []string{
+		"/app",
+		"/app/cmd",
+		"/app/internal",
+		"/app/internal/a",
+		"/app/internal/b",
+		"/app/web",
+		"/app/web/src",
+		"/app/web/node_modules",
+		"/app/web/node_modules/a",
+		"/app/web/node_modules/a/lib",
+		"/app/web/node_modules/b",
+		"/app/web/node_modules/b/lib",
+		"/shared",
+		"/shared/lib",
+	}
+	assert.Equal(t, []watchdirs.Subtree{
+		// Most of the directories of web are in node_modules.
+		{Path: "/app/web/node_modules", Dirs: 5},
+		{Path: "/app/internal", Dirs: 3},
+		{Path: "/app/cmd", Dirs: 1},
+	}, watchdirs.LargestSubtrees(dirs, 3))
+	assert.Len(t, watchdirs.LargestSubtrees(dirs, 10), 4)
+}
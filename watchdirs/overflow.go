@@ -0,0 +1,115 @@
+package watchdirs
+
+import (
+	"slices"
+	"sync"
+)
+
+// OverflowWatcher watches directories with a primary Watcher until it
+// reaches the inotify watch limit, and the directories added afterwards
+// with an overflow Watcher, such as a Poller.
+type OverflowWatcher struct {
+	primary   Watcher
+	overflow  Watcher
+	events    chan WatcherEvent
+	errors    chan error
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	mu        sync.Mutex
+	// overflowed are the directories watched by overflow.
+	overflowed []string
+}
+
+// NewOverflowWatcher returns an OverflowWatcher combining the events and
+// errors of primary and overflow. Both are closed by Close.
+func NewOverflowWatcher(primary, overflow Watcher) *OverflowWatcher {
+	o := &OverflowWatcher{
+		primary:  primary,
+		overflow: overflow,
+		events:   make(chan WatcherEvent),
+		errors:   make(chan error),
+		quit:     make(chan struct{}),
+	}
+	for _, w := range []Watcher{primary, overflow} {
+		o.wg.Add(2)
+		go forward(w.Events(), o.events, o.quit, &o.wg)
+		go forward(w.Errors(), o.errors, o.quit, &o.wg)
+	}
+	go func() {
+		o.wg.Wait()
+		close(o.events)
+		close(o.errors)
+	}()
+	return o
+}
+
+func forward[T any](in <-chan T, out chan<- T, quit <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for v := range in {
+		select {
+		case out <- v:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// Add implements Watcher. The directory name is watched by the overflow
+// watcher when the primary one reaches the watch limit.
+func (o *OverflowWatcher) Add(name string) error {
+	if err := o.primary.Add(name); !IsWatchLimitError(err) {
+		return err
+	} else if err := o.overflow.Add(name); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !slices.Contains(o.overflowed, name) {
+		o.overflowed = append(o.overflowed, name)
+	}
+	return nil
+}
+
+// Remove implements Watcher.
+func (o *OverflowWatcher) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if i := slices.Index(o.overflowed, name); i < 0 {
+		return o.primary.Remove(name)
+	} else {
+		o.overflowed = slices.Delete(o.overflowed, i, i+1)
+		return o.overflow.Remove(name)
+	}
+}
+
+// Overflowed returns the directories watched by the overflow watcher.
+func (o *OverflowWatcher) Overflowed() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.overflowed)
+}
+
+// Events implements Watcher.
+func (o *OverflowWatcher) Events() <-chan WatcherEvent {
+	return o.events
+}
+
+// Errors implements Watcher.
+func (o *OverflowWatcher) Errors() <-chan error {
+	return o.errors
+}
+
+// Close implements Watcher.
+func (o *OverflowWatcher) Close() error {
+	var err error
+	o.closeOnce.Do(func() {
+		close(o.quit)
+		err = o.primary.Close()
+		if overflowErr := o.overflow.Close(); err == nil {
+			err = overflowErr
+		}
+		o.wg.Wait()
+	})
+	return err
+}
@@ -0,0 +1,42 @@
+package watchdirs_test
+
+import (
+	"testing"
+
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowWatcher(t *testing.T) {
+	primary := watchdirs.NewFakeWatcher()
+	primary.SetWatchLimit(2)
+	overflow := watchdirs.NewFakeWatcher()
+	o := watchdirs.NewOverflowWatcher(primary, overflow)
+	for _, d := range []string{"/app", "/app/cmd", "/app/web", "/app/web/src"} {
+		assert.NoError(t, o.Add(d))
+	}
+	assert.Equal(t, []string{"/app", "/app/cmd"}, primary.Dirs())
+	assert.Equal(t, []string{"/app/web", "/app/web/src"}, overflow.Dirs())
+	assert.Equal(t, []string{"/app/web", "/app/web/src"}, o.Overflowed())
+
+	// Events and errors of both watchers are reported.
+	go primary.Send("/app/main.go", watchdirs.WRITE)
+	assert.Equal(t, watchdirs.WatcherEvent{Path: "/app/main.go", Ops: []watchdirs.WatcherEventOp{watchdirs.WRITE}}, <-o.Events())
+	go overflow.Send("/app/web/src/index.js", watchdirs.CREATE)
+	assert.Equal(t, watchdirs.WatcherEvent{Path: "/app/web/src/index.js", Ops: []watchdirs.WatcherEventOp{watchdirs.CREATE}}, <-o.Events())
+	go overflow.SendError(assert.AnError)
+	assert.Equal(t, assert.AnError, <-o.Errors())
+
+	// Directories are removed from the watcher watching them.
+	assert.NoError(t, o.Remove("/app/web"))
+	assert.NoError(t, o.Remove("/app/cmd"))
+	assert.Equal(t, []string{"/app"}, primary.Dirs())
+	assert.Equal(t, []string{"/app/web/src"}, overflow.Dirs())
+	assert.Error(t, o.Remove("/app/cmd"))
+
+	assert.NoError(t, o.Close())
+	_, ok := <-o.Events()
+	assert.False(t, ok)
+	_, ok = <-o.Errors()
+	assert.False(t, ok)
+}
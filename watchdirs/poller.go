@@ -0,0 +1,276 @@
+package watchdirs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Poller reports changes to the files of watched directories by
+	// scanning them periodically, for filesystems on which the
+	// notifications of the operating system are not delivered, such as
+	// network filesystems and the bind mounts of some container
+	// runtimes. Like fsnotify, it reports changes to the entries of each
+	// added directory but not to those of its subdirectories.
+	//
+	// Files are compared by size and modification time or, with
+	// hashing, by size and content. Hashing ignores changes of the
+	// modification time alone, at the cost of reading every file of the
+	// watched directories on each scan.
+	Poller struct {
+		interval  time.Duration
+		hash      bool
+		events    chan WatcherEvent
+		errors    chan error
+		quit      chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+		mu        sync.Mutex
+		// dirs maps the watched directories to the state of their
+		// entries as of the last scan.
+		dirs map[string]map[string]fileState
+	}
+	fileState struct {
+		modTime time.Time
+		size    int64
+		mode    fs.FileMode
+		// sum is the content hash of a regular file, set when hashed.
+		sum    [sha256.Size]byte
+		hashed bool
+	}
+)
+
+// NewPoller returns a Poller scanning the watched directories every
+// interval, comparing file contents when hash is set.
+func NewPoller(interval time.Duration, hash bool) *Poller {
+	p := &Poller{
+		interval: interval,
+		hash:     hash,
+		events:   make(chan WatcherEvent),
+		errors:   make(chan error),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		dirs:     map[string]map[string]fileState{},
+	}
+	go p.run()
+	return p
+}
+
+// Events returns the channel receiving the changes found by each scan.
+// It is closed by Close.
+func (p *Poller) Events() <-chan WatcherEvent {
+	return p.events
+}
+
+// Errors returns the channel receiving the errors of scans. It is closed
+// by Close.
+func (p *Poller) Errors() <-chan error {
+	return p.errors
+}
+
+// Add starts watching the directory dir. Changes are reported from the
+// next scan on.
+func (p *Poller) Add(dir string) error {
+	entries, err := p.scan(dir)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.dirs[dir]; !ok {
+		p.dirs[dir] = entries
+	}
+	return nil
+}
+
+// Remove stops watching the directory dir.
+func (p *Poller) Remove(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.dirs[dir]; !ok {
+		return fmt.Errorf("%s is not watched", dir)
+	}
+	delete(p.dirs, dir)
+	return nil
+}
+
+// Close stops scanning and closes the channels returned by Events and
+// Errors.
+func (p *Poller) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		<-p.done
+	})
+	return nil
+}
+
+func (p *Poller) run() {
+	defer func() {
+		close(p.events)
+		close(p.errors)
+		close(p.done)
+	}()
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+			events, errs := p.poll()
+			for _, ev := range events {
+				select {
+				case p.events <- ev:
+				case <-p.quit:
+					return
+				}
+			}
+			for _, err := range errs {
+				select {
+				case p.errors <- err:
+				case <-p.quit:
+					return
+				}
+			}
+		}
+	}
+}
+
+// poll scans the watched directories and returns the changes since the
+// previous scan.
+func (p *Poller) poll() ([]WatcherEvent, []error) {
+	var (
+		events []WatcherEvent
+		errs   []error
+	)
+	p.mu.Lock()
+	dirs := slices.Sorted(maps.Keys(p.dirs))
+	p.mu.Unlock()
+	// Scan subdirectories before their parents, so that the entries of a
+	// removed directory are reported before the directory itself.
+	for _, dir := range slices.Backward(dirs) {
+		entries, err := p.scan(dir)
+		p.mu.Lock()
+		prev, ok := p.dirs[dir]
+		if !ok {
+			// Removed while scanning.
+		} else if errors.Is(err, fs.ErrNotExist) {
+			// Like fsnotify, stop watching a removed directory after
+			// reporting the removal of its entries.
+			delete(p.dirs, dir)
+			events = append(events, changes(dir, prev, nil)...)
+			if _, ok := p.dirs[filepath.Dir(dir)]; !ok {
+				events = append(events, WatcherEvent{Path: dir, Ops: []WatcherEventOp{REMOVE}})
+			}
+		} else if err != nil {
+			errs = append(errs, err)
+		} else {
+			p.dirs[dir] = entries
+			events = append(events, changes(dir, prev, entries)...)
+		}
+		p.mu.Unlock()
+	}
+	return events, errs
+}
+
+// scan returns the state of the entries of the directory dir.
+func (p *Poller) scan(dir string) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]fileState, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since reading the directory.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		s := fileState{
+			modTime: info.ModTime(),
+			size:    info.Size(),
+			mode:    info.Mode(),
+		}
+		if p.hash && info.Mode().IsRegular() {
+			// Files that cannot be read are compared by modification
+			// time instead.
+			if sum, err := hashFile(filepath.Join(dir, e.Name())); err == nil {
+				s.sum = sum
+				s.hashed = true
+			}
+		}
+		result[e.Name()] = s
+	}
+	return result, nil
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// changes returns the events turning the entries prev of the directory
+// dir into next. Changes to the contents of subdirectories are reported
+// by scanning the subdirectories themselves.
+func changes(dir string, prev, next map[string]fileState) []WatcherEvent {
+	names := slices.Collect(maps.Keys(prev))
+	for name := range next {
+		if _, ok := prev[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	var result []WatcherEvent
+	event := func(name string, op WatcherEventOp) {
+		result = append(result, WatcherEvent{Path: filepath.Join(dir, name), Ops: []WatcherEventOp{op}})
+	}
+	for _, name := range names {
+		o, existed := prev[name]
+		n, exists := next[name]
+		switch {
+		case !existed:
+			event(name, CREATE)
+		case !exists:
+			event(name, REMOVE)
+		case o.mode.Type() != n.mode.Type():
+			event(name, REMOVE)
+			event(name, CREATE)
+		case n.mode.IsDir():
+		case o.size != n.size || o.contentChanged(n):
+			event(name, WRITE)
+		case o.mode != n.mode:
+			event(name, CHMOD)
+		}
+	}
+	return result
+}
+
+// contentChanged reports whether the content of a file of the same size
+// changed from o to n.
+func (o fileState) contentChanged(n fileState) bool {
+	if o.hashed && n.hashed {
+		return o.sum != n.sum
+	}
+	return !o.modTime.Equal(n.modTime)
+}
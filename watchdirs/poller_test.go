@@ -0,0 +1,114 @@
+package watchdirs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+const pollInterval = 10 * time.Millisecond
+
+func TestPoller(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.go")
+	writeFile(t, existing, "package main")
+	p := watchdirs.NewPoller(pollInterval, false)
+	defer p.Close()
+	assert.NoError(t, p.Add(dir))
+
+	// Created files.
+	created := filepath.Join(dir, "created.go")
+	writeFile(t, created, "package main")
+	assertEvent(t, p, created, watchdirs.CREATE)
+
+	// Written files, by size and modification time.
+	writeFile(t, existing, "package main // changed")
+	assertEvent(t, p, existing, watchdirs.WRITE)
+	assert.NoError(t, os.Chtimes(existing, time.Now(), time.Now().Add(time.Hour)))
+	assertEvent(t, p, existing, watchdirs.WRITE)
+
+	// Attribute changes.
+	assert.NoError(t, os.Chmod(existing, 0600))
+	assertEvent(t, p, existing, watchdirs.CHMOD)
+
+	// Removed files.
+	assert.NoError(t, os.Remove(created))
+	assertEvent(t, p, created, watchdirs.REMOVE)
+
+	// Subdirectories are not watched unless added.
+	sub := filepath.Join(dir, "sub")
+	assert.NoError(t, os.Mkdir(sub, 0777))
+	assertEvent(t, p, sub, watchdirs.CREATE)
+	writeFile(t, filepath.Join(sub, "a.go"), "package sub")
+	assertNoEvent(t, p)
+	assert.NoError(t, p.Add(sub))
+	writeFile(t, filepath.Join(sub, "b.go"), "package sub")
+	assertEvent(t, p, filepath.Join(sub, "b.go"), watchdirs.CREATE)
+
+	// Removing a watched directory reports its entries and stops
+	// watching it.
+	assert.NoError(t, os.RemoveAll(sub))
+	assertEvent(t, p, filepath.Join(sub, "a.go"), watchdirs.REMOVE)
+	assertEvent(t, p, filepath.Join(sub, "b.go"), watchdirs.REMOVE)
+	assertEvent(t, p, sub, watchdirs.REMOVE)
+	assert.Error(t, p.Remove(sub))
+
+	// Removed directories are no longer scanned.
+	assert.NoError(t, p.Remove(dir))
+	writeFile(t, created, "package main")
+	assertNoEvent(t, p)
+
+	assert.NoError(t, p.Close())
+	_, ok := <-p.Events()
+	assert.False(t, ok)
+}
+
+func TestPollerHash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	writeFile(t, path, "package main")
+	p := watchdirs.NewPoller(pollInterval, true)
+	defer p.Close()
+	assert.NoError(t, p.Add(dir))
+
+	// Changes of the modification time alone are ignored.
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	assertNoEvent(t, p)
+
+	// Changes of content are reported even when the size and the
+	// modification time are unchanged.
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	writeFile(t, path, "package mine")
+	assert.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	assertEvent(t, p, path, watchdirs.WRITE)
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		assert.FailNow(t, "Error writing file", err)
+	}
+}
+
+func assertEvent(t *testing.T, p *watchdirs.Poller, path string, op watchdirs.WatcherEventOp) {
+	t.Helper()
+	select {
+	case ev := <-p.Events():
+		assert.Equal(t, watchdirs.WatcherEvent{Path: path, Ops: []watchdirs.WatcherEventOp{op}}, ev)
+	case <-time.After(time.Second):
+		assert.FailNow(t, "No event", "expected %s of %s", op, path)
+	}
+}
+
+func assertNoEvent(t *testing.T, p *watchdirs.Poller) {
+	t.Helper()
+	select {
+	case ev := <-p.Events():
+		assert.Fail(t, "Unexpected event", "%v", ev)
+	case <-time.After(5 * pollInterval):
+	}
+}
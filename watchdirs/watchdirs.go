@@ -1,6 +1,7 @@
 package watchdirs
 
 import (
+	"path/filepath"
 	"regexp"
 	"slices"
 
@@ -14,12 +15,29 @@ type (
 	FileChangedEvent struct {
 		Path string
 	}
+	// Filters determine which changes are reported. Changes to any of
+	// AlwaysIncludePaths are reported regardless of the include and
+	// exclude regexes.
+	//
+	// Roots are the directories whose trees are watched. When set, only
+	// changes under a root are reported, and the regexes match either
+	// the path of a file or its path relative to the deepest root holding
+	// it, so that "^vendor/" applies to every root.
+	Filters struct {
+		IncludeFileRegexes []regexp.Regexp
+		ExcludeFileRegexes []regexp.Regexp
+		AlwaysIncludePaths []string
+		Roots              []string
+	}
 )
 
+// StartEventProcessing filters raw watcher events and reports changes to
+// included files on fileChangedChan. Filters received from filterUpdates
+// replace filters for subsequent events.
 func StartEventProcessing(
 	deps Dependencies,
-	includeFileRegexes []regexp.Regexp,
-	excludeFileRegexes []regexp.Regexp,
+	filters Filters,
+	filterUpdates <-chan Filters,
 	fileChangedChan chan<- FileChangedEvent,
 	changes <-chan WatcherEvent,
 	errors <-chan error,
@@ -47,15 +65,16 @@ func StartEventProcessing(
 						break
 					}
 				}
-				if shouldReport {
+				if shouldReport && slices.Contains(filters.AlwaysIncludePaths, ev.Path) {
+					l.Errorf(logger.DEBUG, "File is always included: %s", ev.Path)
+					fileChangedChan <- FileChangedEvent{Path: ev.Path}
+				} else if shouldReport {
 					// Check the filename against the list of include regexes.
-					if slices.IndexFunc(includeFileRegexes, func(r regexp.Regexp) bool {
-						return r.MatchString(ev.Path)
-					}) > -1 {
+					if rel, ok := filters.relativePath(ev.Path); !ok {
+						l.Errorf(logger.DEBUG, "File is outside the watched roots: %s", ev.Path)
+					} else if matchesAny(filters.IncludeFileRegexes, ev.Path, rel) {
 						l.Errorf(logger.DEBUG, "File is included: %s", ev.Path)
-						if slices.IndexFunc(excludeFileRegexes, func(r regexp.Regexp) bool {
-							return r.MatchString(ev.Path)
-						}) > -1 {
+						if matchesAny(filters.ExcludeFileRegexes, ev.Path, rel) {
 							l.Errorf(logger.DEBUG, "File is excluded: %s", ev.Path)
 						} else {
 							fileChangedChan <- FileChangedEvent{Path: ev.Path}
@@ -67,6 +86,9 @@ func StartEventProcessing(
 			} else {
 				changes = nil
 			}
+		case f := <-filterUpdates:
+			l.Errorf(logger.DEBUG, "Watch filters updated")
+			filters = f
 		case err, ok := <-errors:
 			if ok {
 				l.Errorf(logger.ERROR, "Error watching directories: %s", err)
@@ -79,3 +101,45 @@ func StartEventProcessing(
 		}
 	}
 }
+
+// Matches reports whether changes to path pass f.
+func (f Filters) Matches(path string) bool {
+	if slices.Contains(f.AlwaysIncludePaths, path) {
+		return true
+	}
+	rel, ok := f.relativePath(path)
+	return ok &&
+		matchesAny(f.IncludeFileRegexes, path, rel) &&
+		!matchesAny(f.ExcludeFileRegexes, path, rel)
+}
+
+// relativePath returns path relative to the deepest of the roots of f
+// holding it, and whether any of them does.
+func (f Filters) relativePath(path string) (string, bool) {
+	return RelativePath(f.Roots, path)
+}
+
+// RelativePath returns path relative to the deepest of roots holding it,
+// and whether any of them does. Without roots, path is returned as is.
+func RelativePath(roots []string, path string) (string, bool) {
+	if len(roots) == 0 {
+		return path, true
+	}
+	result, found := "", false
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err != nil || !filepath.IsLocal(rel) {
+			continue
+		} else if !found || len(rel) < len(result) {
+			result, found = rel, true
+		}
+	}
+	return result, found
+}
+
+// matchesAny reports whether any of regexes matches path or its
+// relative path rel.
+func matchesAny(regexes []regexp.Regexp, path, rel string) bool {
+	return slices.IndexFunc(regexes, func(r regexp.Regexp) bool {
+		return r.MatchString(path) || r.MatchString(rel)
+	}) > -1
+}
@@ -0,0 +1,87 @@
+package watchdirs_test
+
+import (
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/watchdirs"
+	"github.com/stretchr/testify/assert"
+)
+
+type testDeps struct{}
+
+// Logger implements watchdirs.Dependencies.
+func (testDeps) Logger() logger.Logger {
+	return logger.NewLogger("test", io.Discard)
+}
+
+func TestStartEventProcessing(t *testing.T) {
+	w := watchdirs.NewFakeWatcher()
+	assert.NoError(t, w.Add("/app"))
+	assert.NoError(t, w.Add("/app/cmd"))
+	assert.NoError(t, w.Remove("/app/cmd"))
+	assert.Error(t, w.Remove("/app/cmd"))
+	assert.Equal(t, []string{"/app"}, w.Dirs())
+	filters := watchdirs.Filters{
+		IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.go$`)},
+		ExcludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`_test\.go$`)},
+		AlwaysIncludePaths: []string{"/app/.env"},
+	}
+	filterUpdates := make(chan watchdirs.Filters)
+	out := make(chan watchdirs.FileChangedEvent, 10)
+	done := make(chan bool, 1)
+	go watchdirs.StartEventProcessing(testDeps{}, filters, filterUpdates, out, w.Events(), w.Errors(), done)
+	w.Send("/app/main.go", watchdirs.WRITE)
+	w.Send("/app/main_test.go", watchdirs.WRITE)
+	w.Send("/app/README.md", watchdirs.CREATE)
+	w.Send("/app/.env", watchdirs.WRITE)
+	w.Send("/app/util.go", watchdirs.CHMOD)
+	w.Send("/app/util.go", watchdirs.CHMOD, watchdirs.RENAME)
+	w.SendError(assert.AnError)
+	filterUpdates <- watchdirs.Filters{
+		IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.md$`)},
+	}
+	w.Send("/app/main.go", watchdirs.WRITE)
+	w.Send("/app/README.md", watchdirs.REMOVE)
+	w.Close()
+	<-done
+	close(out)
+	var reported []string
+	for ev := range out {
+		reported = append(reported, ev.Path)
+	}
+	assert.Equal(t, []string{"/app/main.go", "/app/.env", "/app/util.go", "/app/README.md"}, reported)
+}
+
+func TestStartEventProcessingRoots(t *testing.T) {
+	w := watchdirs.NewFakeWatcher()
+	filters := watchdirs.Filters{
+		IncludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`\.go$`)},
+		ExcludeFileRegexes: []regexp.Regexp{*regexp.MustCompile(`^vendor/`)},
+		AlwaysIncludePaths: []string{"/etc/app/app.yaml"},
+		Roots:              []string{"/src/app", "/src/shared", "/src/app/vendor/lib"},
+	}
+	out := make(chan watchdirs.FileChangedEvent, 10)
+	done := make(chan bool, 1)
+	go watchdirs.StartEventProcessing(testDeps{}, filters, nil, out, w.Events(), w.Errors(), done)
+	// Excluded relative to each root.
+	w.Send("/src/app/vendor/dep/dep.go", watchdirs.WRITE)
+	w.Send("/src/shared/vendor/dep/dep.go", watchdirs.WRITE)
+	// Matched relative to the deepest root.
+	w.Send("/src/app/vendor/lib/lib.go", watchdirs.WRITE)
+	w.Send("/src/shared/util.go", watchdirs.WRITE)
+	// Outside the roots unless always included.
+	w.Send("/src/other/other.go", watchdirs.WRITE)
+	w.Send("/etc/app/other.yaml", watchdirs.WRITE)
+	w.Send("/etc/app/app.yaml", watchdirs.WRITE)
+	w.Close()
+	<-done
+	close(out)
+	var reported []string
+	for ev := range out {
+		reported = append(reported, ev.Path)
+	}
+	assert.Equal(t, []string{"/src/app/vendor/lib/lib.go", "/src/shared/util.go", "/etc/app/app.yaml"}, reported)
+}
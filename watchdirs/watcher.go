@@ -0,0 +1,127 @@
+package watchdirs
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports changes to the entries of registered directories, but
+// not to those of their subdirectories. The events and errors of a
+// Watcher are the input of StartEventProcessing.
+type Watcher interface {
+	// Add starts watching the directory name.
+	Add(name string) error
+	// Remove stops watching the directory name.
+	Remove(name string) error
+	// Events returns the channel receiving changes. It is closed by
+	// Close.
+	Events() <-chan WatcherEvent
+	// Errors returns the channel receiving errors. It is closed by
+	// Close.
+	Errors() <-chan error
+	// Close stops watching every directory.
+	Close() error
+}
+
+// FSNotifyWatcher is a Watcher relying on the notifications of the
+// operating system through fsnotify.
+type FSNotifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan WatcherEvent
+	errors chan error
+	closed chan struct{}
+}
+
+// NewFSNotifyWatcher returns an FSNotifyWatcher watching no directories.
+func NewFSNotifyWatcher() (*FSNotifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	result := &FSNotifyWatcher{
+		w:      w,
+		events: make(chan WatcherEvent),
+		errors: make(chan error),
+		closed: make(chan struct{}),
+	}
+	go result.translate()
+	return result, nil
+}
+
+// Add implements Watcher.
+func (f *FSNotifyWatcher) Add(name string) error {
+	return f.w.Add(name)
+}
+
+// Remove implements Watcher.
+func (f *FSNotifyWatcher) Remove(name string) error {
+	return f.w.Remove(name)
+}
+
+// Events implements Watcher.
+func (f *FSNotifyWatcher) Events() <-chan WatcherEvent {
+	return f.events
+}
+
+// Errors implements Watcher.
+func (f *FSNotifyWatcher) Errors() <-chan error {
+	return f.errors
+}
+
+// Close implements Watcher.
+func (f *FSNotifyWatcher) Close() error {
+	close(f.closed)
+	return f.w.Close()
+}
+
+// translate forwards the events of the fsnotify watcher until Close is
+// called.
+func (f *FSNotifyWatcher) translate() {
+	defer func() {
+		close(f.events)
+		close(f.errors)
+	}()
+	for {
+		select {
+		case <-f.closed:
+			return
+		case ev, ok := <-f.w.Events:
+			if !ok {
+				return
+			}
+			select {
+			case f.events <- translateEvent(ev):
+			case <-f.closed:
+				return
+			}
+		case err, ok := <-f.w.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case f.errors <- err:
+			case <-f.closed:
+				return
+			}
+		}
+	}
+}
+
+func translateEvent(ev fsnotify.Event) WatcherEvent {
+	var ops []WatcherEventOp
+	if ev.Op.Has(fsnotify.Chmod) {
+		ops = append(ops, CHMOD)
+	}
+	if ev.Op.Has(fsnotify.Create) {
+		ops = append(ops, CREATE)
+	}
+	if ev.Op.Has(fsnotify.Remove) {
+		ops = append(ops, REMOVE)
+	}
+	if ev.Op.Has(fsnotify.Rename) {
+		ops = append(ops, RENAME)
+	}
+	if ev.Op.Has(fsnotify.Write) {
+		ops = append(ops, WRITE)
+	}
+	return WatcherEvent{Path: ev.Name, Ops: ops}
+}
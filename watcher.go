@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jakewan/go-procrotator/logger"
+	"github.com/jakewan/go-procrotator/runtimeconfig"
+	"github.com/jakewan/go-procrotator/watchdirs"
+)
+
+// newFileWatcher returns the watcher selected by the watcher setting of
+// cfg, watching dirs. The auto setting falls back to polling when
+// fsnotify fails or the first of dirs is on a filesystem that does not
+// deliver its notifications, warning along with the ways of avoiding the
+// inotify watch limit when it was reached. The fanotify setting falls
+// back to fsnotify when fanotify is unavailable.
+func newFileWatcher(l logger.Logger, cfg runtimeconfig.Config, dirs []string) (watchdirs.Watcher, error) {
+	switch cfg.Watcher() {
+	case runtimeconfig.PollWatcher:
+		return newPoller(cfg, dirs)
+	case runtimeconfig.FSNotifyWatcher:
+		return newFSNotifyWatcher(l, cfg, dirs)
+	case runtimeconfig.FanotifyWatcher:
+		if w, err := newFanotifyWatcher(dirs); err != nil {
+			l.Errorf(logger.WARNING, "Watching with inotify since fanotify is unavailable: %s", err)
+			return newFSNotifyWatcher(l, cfg, dirs)
+		} else {
+			return w, nil
+		}
+	}
+	if len(dirs) > 0 {
+		if fsType, ok := watchdirs.PollingFilesystem(dirs[0]); ok {
+			l.Errorf(logger.NOTICE, "Polling for changes since %s is on a %s filesystem", dirs[0], fsType)
+			return newPoller(cfg, dirs)
+		}
+	}
+	if w, err := newFSNotifyWatcher(l, cfg, dirs); watchdirs.IsWatchLimitError(err) {
+		// The error explains how to avoid the limit, since polling every
+		// directory is much slower than watching them.
+		l.Errorf(logger.WARNING, "%s\nPolling for changes instead.", err)
+		return newPoller(cfg, dirs)
+	} else if err != nil {
+		l.Errorf(logger.WARNING, "Polling for changes since fsnotify failed: %s", err)
+		return newPoller(cfg, dirs)
+	} else {
+		return w, nil
+	}
+}
+
+func newPoller(cfg runtimeconfig.Config, dirs []string) (watchdirs.Watcher, error) {
+	return addDirs(watchdirs.NewPoller(cfg.PollInterval(), cfg.PollHash()), dirs)
+}
+
+// newFSNotifyWatcher returns an fsnotify watcher watching dirs. With the
+// poll_over_watch_limit setting, the directories beyond the inotify watch
+// limit are polled. Otherwise reaching the limit is an error explaining
+// how to avoid it.
+func newFSNotifyWatcher(l logger.Logger, cfg runtimeconfig.Config, dirs []string) (watchdirs.Watcher, error) {
+	w, err := watchdirs.NewFSNotifyWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.PollOverWatchLimit() {
+		return addLimitedDirs(cfg, w, dirs)
+	}
+	o := watchdirs.NewOverflowWatcher(w, watchdirs.NewPoller(cfg.PollInterval(), cfg.PollHash()))
+	if _, err := addDirs(o, dirs); err != nil {
+		return nil, err
+	}
+	if overflowed := o.Overflowed(); len(overflowed) > 0 {
+		l.Errorf(logger.WARNING, "%s\nPolling the %d directories beyond the limit instead.", watchLimitReport(cfg, dirs), len(overflowed))
+	}
+	return o, nil
+}
+
+func newFanotifyWatcher(dirs []string) (watchdirs.Watcher, error) {
+	if w, err := watchdirs.NewFanotifyWatcher(); err != nil {
+		return nil, err
+	} else {
+		return addDirs(w, dirs)
+	}
+}
+
+// addLimitedDirs adds dirs to the inotify watcher w. Reaching the watch
+// limit is an error explaining how to avoid it for the configuration cfg.
+func addLimitedDirs(cfg runtimeconfig.Config, w watchdirs.Watcher, dirs []string) (watchdirs.Watcher, error) {
+	if w, err := addDirs(w, dirs); watchdirs.IsWatchLimitError(err) {
+		return nil, fmt.Errorf("watching directories: %w\n%s", err, watchLimitReport(cfg, dirs))
+	} else {
+		return w, err
+	}
+}
+
+// addDirs adds dirs to the watcher w, closing it if any of them cannot
+// be watched.
+func addDirs(w watchdirs.Watcher, dirs []string) (watchdirs.Watcher, error) {
+	for _, d := range dirs {
+		if err := w.Add(d); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// watchLimitReport explains that watching dirs exceeds the inotify watch
+// limit, listing the largest subtrees and the ways to avoid the limit.
+// Ignoring a subtree is only suggested when it holds a tenth of dirs or
+// more.
+func watchLimitReport(cfg runtimeconfig.Config, dirs []string) string {
+	limit, limitErr := watchdirs.MaxUserWatches()
+	var lines []string
+	if limitErr != nil {
+		lines = append(lines, fmt.Sprintf(
+			"Reached the inotify watch limit: each of the %d watched directories needs a watch.",
+			len(dirs),
+		))
+	} else {
+		lines = append(lines, fmt.Sprintf(
+			"Reached the inotify watch limit: each of the %d watched directories needs a watch, "+
+				"and fs.inotify.max_user_watches allows %d for all the programs of the user.",
+			len(dirs),
+			limit,
+		))
+	}
+	ignore := slices.Clone(cfg.IgnoreDirectories())
+	suggested := false
+	if subtrees := watchdirs.LargestSubtrees(dirs, 5); len(subtrees) > 0 {
+		lines = append(lines, "The largest subtrees, with their number of directories, are:")
+		for _, s := range subtrees {
+			lines = append(lines, fmt.Sprintf("  %s: %d", s.Path, s.Dirs))
+			if name := filepath.Base(s.Path); s.Dirs*10 >= len(dirs) && !slices.Contains(ignore, name) {
+				ignore = append(ignore, name)
+				suggested = true
+			}
+		}
+	}
+	if suggested {
+		quoted := make([]string, 0, len(ignore))
+		for _, name := range ignore {
+			quoted = append(quoted, fmt.Sprintf("%q", name))
+		}
+		lines = append(lines, fmt.Sprintf("Skip those that need not be watched with: ignore_directories = [%s]", strings.Join(quoted, ", ")))
+	}
+	if cfg.PollOverWatchLimit() {
+		lines = append(lines, `Or set watcher = "fanotify" to watch them without inotify watches.`)
+	} else {
+		lines = append(lines, `Or set watcher = "fanotify" or poll_over_watch_limit = true to watch them without inotify watches.`)
+	}
+	if limitErr == nil {
+		lines = append(lines, fmt.Sprintf(
+			"Or raise the limit with: sysctl fs.inotify.max_user_watches=%d",
+			1<<bits.Len(uint(len(dirs)+limit)),
+		))
+	}
+	return strings.Join(lines, "\n")
+}